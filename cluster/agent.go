@@ -1,12 +1,14 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
 
 	"github.com/Zwlin98/moon/gate"
 	"github.com/Zwlin98/moon/lua"
+	"github.com/Zwlin98/moon/service"
 )
 
 // execute request from other skynet node
@@ -21,6 +23,7 @@ type skynetClusterAgent struct {
 	gate     gate.Gate
 
 	pendingReqs map[uint32]Request
+	traceTag    string // 最近收到的 trace 包, 属于下一个请求
 
 	respChan chan PackedResponse
 	exit     chan struct{}
@@ -94,6 +97,16 @@ func (ca *skynetClusterAgent) dispatch(msg []byte) {
 	req, err := UnpackRequest(msg)
 	if err != nil {
 		slog.Error("ClusterAgent dispatch error", "error", err)
+		return
+	}
+	if req.IsTrace {
+		ca.traceTag = req.TraceTag
+		return
+	}
+	// 多包请求的后续部分没有地址, trace tag 已经记录在第一个包中
+	if req.Address != nil {
+		req.TraceTag = ca.traceTag
+		ca.traceTag = ""
 	}
 	session := req.Session
 
@@ -122,7 +135,13 @@ func (ca *skynetClusterAgent) execute(req Request) {
 		}
 	}()
 
-	svc := ca.clusterd.Query(req.Address)
+	if req.Address == uint32(0) {
+		ca.queryName(req)
+		return
+	}
+
+	svc, release := ca.clusterd.acquire(req.Address)
+	defer release()
 	if svc == nil {
 		ca.sendError(req, fmt.Errorf("service not found: %v", req.Address))
 		return
@@ -134,27 +153,23 @@ func (ca *skynetClusterAgent) execute(req Request) {
 		return
 	}
 
-	ret, err := svc.Execute(args)
+	ctx := context.Background()
+	if req.TraceTag != "" {
+		ctx = service.WithTraceTag(ctx, req.TraceTag)
+	}
+	ret, err := service.Execute(ctx, svc, args)
 	if err != nil {
 		ca.sendError(req, err)
 		return
 	}
 
 	if !req.IsPush {
-		serialized, err := lua.Serialize(ret)
-
-		if err != nil {
-			ca.sendError(req, err)
-			return
-		}
-
 		resp := Response{
 			Ok:      true,
 			Session: req.Session,
-			Msg:     serialized,
 		}
 
-		packedResp, err := PackResponse(resp)
+		packedResp, err := PackResponseValues(resp, ret)
 
 		if err != nil {
 			ca.sendError(req, err)
@@ -168,19 +183,49 @@ func (ca *skynetClusterAgent) execute(req Request) {
 	}
 }
 
+// queryName 回应 skynet cluster.query 和 cluster.proxy 发送到地址 0 的名字查询
+func (ca *skynetClusterAgent) queryName(req Request) {
+	args, err := lua.Deserialize(req.Msg)
+	if err != nil {
+		ca.sendError(req, err)
+		return
+	}
+	if len(args) < 1 || args[0].LuaType() != lua.LUA_STRING {
+		ca.sendError(req, fmt.Errorf("invalid name query"))
+		return
+	}
+	handle, ok := ca.clusterd.queryName(lua.MustString(args[0]))
+	if !ok {
+		ca.sendError(req, fmt.Errorf("name not found"))
+		return
+	}
+	if req.IsPush {
+		return
+	}
+	ret, err := lua.TryArgs(handle)
+	if err != nil {
+		ca.sendError(req, err)
+		return
+	}
+	packedResp, err := PackResponseValues(Response{Ok: true, Session: req.Session}, ret)
+	if err != nil {
+		ca.sendError(req, err)
+		return
+	}
+	ca.safeSend(packedResp)
+}
+
 func (ca *skynetClusterAgent) sendError(req Request, err error) {
 	slog.Warn("ClusterAgent send error", "addr", ca.conn.RemoteAddr(), "error", err)
 	if req.IsPush {
 		return
 	}
-	ret := []lua.Value{lua.String(err.Error())}
-	serialized, _ := lua.Serialize(ret)
 	resp := Response{
 		Ok:      false,
 		Session: req.Session,
-		Msg:     serialized,
 	}
-	packedResp, _ := PackResponse(resp)
+	msg, _ := lua.TryArgs(err.Error())
+	packedResp, _ := PackResponseValues(resp, msg)
 
 	ca.safeSend(packedResp)
 }
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Zwlin98/moon/lua"
+	"github.com/Zwlin98/moon/service"
+)
+
+// traceService 返回请求的 trace tag 以及参数的长度
+type traceService struct{}
+
+func (traceService) Execute(args []lua.Value) ([]lua.Value, error) {
+	return nil, nil
+}
+
+func (traceService) ExecuteContext(ctx context.Context, args []lua.Value) ([]lua.Value, error) {
+	tag, _ := service.TraceTag(ctx)
+	s, _ := lua.As[string](args[1])
+	return lua.Args(tag, len(s)), nil
+}
+
+func TestTraceFrame(t *testing.T) {
+	pr, err := PackRequestValues(Request{Address: "svc", Session: 1, TraceTag: ":00000010-1"}, lua.Args("x"))
+	if err != nil {
+		t.Fatalf("pack failed: %v", err)
+	}
+	req, err := UnpackRequest(pr.Trace)
+	if err != nil || !req.IsTrace || req.TraceTag != ":00000010-1" {
+		t.Errorf("trace frame not match: %+v %v", req, err)
+	}
+	if _, err := PackTrace(string(bytes.Repeat([]byte("x"), MULTI_PART+1))); err == nil {
+		t.Errorf("expected trace tag too long error")
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	c := testClusterd(t)
+	c.Register("trace", traceService{})
+	client := c.fetchSender("self")
+
+	tagged := service.WithTraceTag(testContext(t), ":00000010-1")
+	large := string(bytes.Repeat([]byte("x"), 3*MULTI_PART))
+	cases := []struct {
+		ctx  context.Context
+		arg  string
+		want string
+	}{
+		{tagged, "single", ":00000010-1"},
+		{testContext(t), "single", ""}, // trace tag 只属于下一个请求
+		{tagged, large, ":00000010-1"}, // MULTI_PART
+		{testContext(t), large, ""},
+	}
+	for i, cs := range cases {
+		ret, err := client.Call(cs.ctx, "trace", "echo", lua.Args(cs.arg))
+		if err != nil {
+			t.Fatalf("case %d: call failed: %v", i, err)
+		}
+		lua.AssertEqual(t, lua.Table{Array: lua.Args(cs.want, len(cs.arg))}, lua.Table{Array: ret})
+	}
+}
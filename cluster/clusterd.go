@@ -1,10 +1,15 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Zwlin98/moon/gate"
 	"github.com/Zwlin98/moon/lua"
@@ -15,25 +20,46 @@ type Clusterd interface {
 	Reload(ClusterConfig)
 
 	Register(any, service.Service) error
+	Unregister(any) error
+	Replace(any, service.Service) error
 	Query(any) service.Service
 
 	Open(string) error
 	OnConnect(gate gate.Gate, conn net.Conn)
 
+	NodeStates() map[string]SenderState
+
 	fetchSender(string) Sender
+	waitSender(context.Context, string) (Sender, error)
+	queryName(string) (uint32, bool)
+	acquire(any) (service.Service, func())
 	OnSenderExit(string)
 }
 
 type skynetClusterd struct {
 	sync.Mutex
 
-	config ClusterConfig
+	config   ClusterConfig
+	reloaded chan struct{} // Reload 时关闭, 唤醒等待节点配置的调用
 
-	namedServices map[string]service.Service
+	// 每个服务都有一个数字地址, 具名服务额外记录名字到地址的映射
+	servicesLock  sync.RWMutex
+	services      map[uint32]*serviceEntry
+	namedServices map[string]uint32
+	nextAddress   uint32
 
 	nodeSender sync.Map
 
-	gate map[string]gate.Gate
+	// Reload 与 Open 可能在不同的协程中调用, 例如 WatchConfigFile
+	gateLock sync.Mutex
+	gate     map[string]gate.Gate
+}
+
+type serviceEntry struct {
+	svc      service.Service
+	name     string // 具名服务的名字
+	address  uint32
+	inflight sync.WaitGroup // 正在执行的调用, 注销时等待结束后再 Close
 }
 
 var globalClusterd Clusterd
@@ -48,64 +74,243 @@ func GetClusterd() Clusterd {
 
 func newClusterd() *skynetClusterd {
 	return &skynetClusterd{
-		namedServices: make(map[string]service.Service),
+		services:      make(map[uint32]*serviceEntry),
+		namedServices: make(map[string]uint32),
 		gate:          make(map[string]gate.Gate),
 		config:        make(DefaultConfig),
+		reloaded:      make(chan struct{}),
+	}
+}
+
+var callTimeout atomic.Int64
+
+func init() {
+	callTimeout.Store(int64(DEFAULT_CALL_TIMEOUT))
+}
+
+// SetCallTimeout 设置 Call 的默认超时, d <= 0 时不超时
+func SetCallTimeout(d time.Duration) {
+	callTimeout.Store(int64(d))
+}
+
+// Call 调用 node 上的服务, address 为服务名 (string) 或数字地址 (uint32), 使用 SetCallTimeout 设置的超时
+func Call(node string, address any, method string, args []lua.Value) ([]lua.Value, error) {
+	ctx := context.Background()
+	if d := time.Duration(callTimeout.Load()); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
 	}
+	return CallContext(ctx, node, address, method, args)
 }
 
-func Call(node string, service string, method string, args []lua.Value) ([]lua.Value, error) {
-	c := GetClusterd()
-	client := c.fetchSender(node)
-	if client == nil {
-		return nil, fmt.Errorf("no client for node: %s", node)
+func Send(node string, address any, method string, args []lua.Value) error {
+	return SendContext(context.Background(), node, address, method, args)
+}
+
+// CallContext 与 Call 相同, 但超时和取消由 ctx 决定, 取消后到达的回应会被丢弃.
+// ctx 带有 trace tag 时 (见 service.WithTraceTag) 随请求发送 trace 包
+func CallContext(ctx context.Context, node string, address any, method string, args []lua.Value) ([]lua.Value, error) {
+	client, err := GetClusterd().waitSender(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	return client.Call(ctx, address, method, args)
+}
+
+func SendContext(ctx context.Context, node string, address any, method string, args []lua.Value) error {
+	client, err := GetClusterd().waitSender(ctx, node)
+	if err != nil {
+		return err
 	}
-	return client.Call(service, method, args)
+	return client.Send(ctx, address, method, args)
 }
 
-func Send(node string, service string, method string, args []lua.Value) error {
-	c := GetClusterd()
-	client := c.fetchSender(node)
-	if client == nil {
-		return fmt.Errorf("no client for node: %s", node)
+// QueryName 与 skynet 的 cluster.query 相同, 查询 node 上具名服务的数字地址
+func QueryName(node string, name string) (uint32, error) {
+	ret, err := Call(node, uint32(0), name, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(ret) < 1 {
+		return 0, fmt.Errorf("query %s.%s: empty response", node, name)
 	}
-	return client.Send(service, method, args)
+	return lua.As[uint32](ret[0])
+}
+
+// lookup 查找服务, 名字可以带 skynet 的 '@' 前缀, 调用方需要持有 servicesLock
+func (c *skynetClusterd) lookup(address any) *serviceEntry {
+	switch addr := address.(type) {
+	case string:
+		if handle, ok := c.namedServices[strings.TrimPrefix(addr, "@")]; ok {
+			return c.services[handle]
+		}
+	case uint32:
+		return c.services[addr]
+	}
+	return nil
 }
 
 func (c *skynetClusterd) Query(address any) service.Service {
-	if addr, ok := address.(string); ok {
-		if svc, ok := c.namedServices[addr]; ok {
-			return svc
+	c.servicesLock.RLock()
+	defer c.servicesLock.RUnlock()
+	if entry := c.lookup(address); entry != nil {
+		return entry.svc
+	}
+	return nil
+}
+
+// acquire 查找服务并记录一次调用, 调用结束后需要调用返回的 release
+func (c *skynetClusterd) acquire(address any) (service.Service, func()) {
+	c.servicesLock.RLock()
+	defer c.servicesLock.RUnlock()
+	entry := c.lookup(address)
+	if entry == nil {
+		return nil, func() {}
+	}
+	entry.inflight.Add(1)
+	return entry.svc, entry.inflight.Done
+}
+
+// queryName 返回具名服务的数字地址, 名字可以带 skynet 的 '@' 前缀
+func (c *skynetClusterd) queryName(name string) (uint32, bool) {
+	c.servicesLock.RLock()
+	defer c.servicesLock.RUnlock()
+	handle, ok := c.namedServices[strings.TrimPrefix(name, "@")]
+	return handle, ok
+}
+
+// allocAddress 分配一个未使用的数字地址, 0 保留给名字查询, 调用方需要持有 servicesLock
+func (c *skynetClusterd) allocAddress() uint32 {
+	for {
+		c.nextAddress++
+		if _, ok := c.services[c.nextAddress]; !ok && c.nextAddress != 0 {
+			return c.nextAddress
 		}
 	}
+}
+
+func initService(svc service.Service) error {
+	if init, ok := svc.(service.Initializer); ok {
+		return init.Init(context.Background())
+	}
+	return nil
+}
+
+// closeService 等待正在执行的调用结束后关闭服务
+func closeService(entry *serviceEntry) error {
+	entry.inflight.Wait()
+	if closer, ok := entry.svc.(io.Closer); ok {
+		return closer.Close()
+	}
 	return nil
 }
 
-// TODO: int address type
+// Register 注册服务, address 为服务名时自动分配数字地址, 也可以直接指定数字地址 (uint32).
+// 服务实现了 service.Initializer 时先调用 Init
 func (c *skynetClusterd) Register(address any, svc service.Service) error {
-	if addr, ok := address.(string); ok {
-		if _, ok := c.namedServices[addr]; ok {
-			return fmt.Errorf("service already registered: %s", addr)
+	switch addr := address.(type) {
+	case string:
+		if strings.TrimPrefix(addr, "@") == "" {
+			return fmt.Errorf("service name is empty")
 		}
-		c.namedServices[addr] = svc
-		return nil
+	case uint32:
+		if addr == 0 {
+			return fmt.Errorf("address 0 is reserved")
+		}
+	default:
+		return fmt.Errorf("invalid address type: %T", address)
+	}
+	if c.Query(address) != nil {
+		return fmt.Errorf("service already registered: %v", address)
+	}
+	if err := initService(svc); err != nil {
+		return fmt.Errorf("init service %v: %w", address, err)
+	}
+
+	entry := &serviceEntry{svc: svc}
+	c.servicesLock.Lock()
+	// Init 期间可能有相同地址的服务注册, 与 Replace 相同, 释放锁之后再 Close
+	if c.lookup(address) != nil {
+		c.servicesLock.Unlock()
+		closeService(entry)
+		return fmt.Errorf("service already registered: %v", address)
+	}
+	switch addr := address.(type) {
+	case string:
+		entry.name = strings.TrimPrefix(addr, "@")
+		entry.address = c.allocAddress()
+		c.namedServices[entry.name] = entry.address
+	case uint32:
+		entry.address = addr
 	}
-	return fmt.Errorf("invalid address type: %T", address)
+	c.services[entry.address] = entry
+	c.servicesLock.Unlock()
+	return nil
 }
 
-func (c *skynetClusterd) Reload(config ClusterConfig) {
-	if c.config == nil {
-		c.config = config
-		return
+// Unregister 注销服务, address 为服务名或数字地址. 服务实现了 io.Closer 时,
+// 等待正在执行的调用结束后调用 Close, 返回 Close 的错误
+func (c *skynetClusterd) Unregister(address any) error {
+	c.servicesLock.Lock()
+	entry := c.lookup(address)
+	if entry == nil {
+		c.servicesLock.Unlock()
+		return fmt.Errorf("service not registered: %v", address)
+	}
+	delete(c.services, entry.address)
+	if entry.name != "" {
+		delete(c.namedServices, entry.name)
+	}
+	c.servicesLock.Unlock()
+
+	return closeService(entry)
+}
+
+// Replace 替换已经注册的服务, 名字和数字地址保持不变. 新的调用立即使用 svc,
+// 旧的服务在正在执行的调用结束后 Close
+func (c *skynetClusterd) Replace(address any, svc service.Service) error {
+	if c.Query(address) == nil {
+		return fmt.Errorf("service not registered: %v", address)
+	}
+	if err := initService(svc); err != nil {
+		return fmt.Errorf("init service %v: %w", address, err)
+	}
+
+	entry := &serviceEntry{svc: svc}
+	c.servicesLock.Lock()
+	old := c.lookup(address)
+	if old == nil {
+		c.servicesLock.Unlock()
+		closeService(entry)
+		return fmt.Errorf("service not registered: %v", address)
 	}
+	entry.name, entry.address = old.name, old.address
+	c.services[old.address] = entry
+	c.servicesLock.Unlock()
+
+	return closeService(old)
+}
+
+func (c *skynetClusterd) Reload(config ClusterConfig) {
+	c.Lock()
+	c.config = config
+	close(c.reloaded)
+	c.reloaded = make(chan struct{})
+	c.Unlock()
+
 	//self address change check
+	c.gateLock.Lock()
 	for name, gate := range c.gate {
 		if config.NodeInfo(name) != gate.Address() {
 			gate.Stop()
 			delete(c.gate, name)
-			c.Open(name)
+			if err := c.open(name); err != nil {
+				slog.Error("failed to reopen gate", "name", name, "error", err)
+			}
 		}
 	}
+	c.gateLock.Unlock()
 	// node address change check
 	c.nodeSender.Range(func(key, value interface{}) bool {
 		name := key.(string)
@@ -116,13 +321,42 @@ func (c *skynetClusterd) Reload(config ClusterConfig) {
 		}
 		return true
 	})
-	c.config = config
+}
+
+func (c *skynetClusterd) getConfig() (ClusterConfig, chan struct{}) {
+	c.Lock()
+	defer c.Unlock()
+	return c.config, c.reloaded
+}
+
+// waitSender 与 skynet 的 clusterd 相同, 节点不在配置中时等待配置重新加载, 除非配置了 __nowaiting
+func (c *skynetClusterd) waitSender(ctx context.Context, name string) (Sender, error) {
+	for {
+		config, reloaded := c.getConfig()
+		if client := c.fetchSender(name); client != nil {
+			return client, nil
+		}
+		status, ok := config.(NodeStatusConfig)
+		if ok && status.NodeDown(name) {
+			return nil, fmt.Errorf("node %s is down", name)
+		}
+		if !ok || status.NoWaiting() {
+			return nil, fmt.Errorf("no client for node: %s", name)
+		}
+		slog.Info("waiting for cluster config", "node", name)
+		select {
+		case <-reloaded:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for node %s: %w", name, ctx.Err())
+		}
+	}
 }
 
 var mutex sync.Mutex
 
 func (c *skynetClusterd) fetchSender(name string) Sender {
-	addr := c.config.NodeInfo(name)
+	config, _ := c.getConfig()
+	addr := config.NodeInfo(name)
 	if addr == "" {
 		return nil
 	}
@@ -136,15 +370,27 @@ func (c *skynetClusterd) fetchSender(name string) Sender {
 	if client, ok := c.nodeSender.Load(name); ok {
 		return client.(Sender)
 	}
-	client, error := NewClusterClient(c, name, addr)
-	if error != nil {
-		return nil
-	}
+	client := NewClusterClient(c, name, addr)
 	client.Start()
 	c.nodeSender.Store(name, client)
 	return client
 }
 
+// NodeStates 返回已经建立的节点连接的状态, 没有调用过的节点不在其中
+func (c *skynetClusterd) NodeStates() map[string]SenderState {
+	states := make(map[string]SenderState)
+	c.nodeSender.Range(func(key, value any) bool {
+		states[key.(string)] = value.(Sender).State()
+		return true
+	})
+	return states
+}
+
+// NodeStates 返回全局 Clusterd 中节点连接的状态
+func NodeStates() map[string]SenderState {
+	return GetClusterd().NodeStates()
+}
+
 // OnSenderExit implements Clusterd.
 func (c *skynetClusterd) OnSenderExit(name string) {
 	slog.Info("client removed", "name", name)
@@ -157,16 +403,29 @@ func (c *skynetClusterd) OnConnect(gate gate.Gate, conn net.Conn) {
 }
 
 func (c *skynetClusterd) Open(name string) error {
-	if c.config == nil {
+	c.gateLock.Lock()
+	defer c.gateLock.Unlock()
+	return c.open(name)
+}
+
+// open 调用方需要持有 gateLock
+func (c *skynetClusterd) open(name string) error {
+	config, _ := c.getConfig()
+	if config == nil {
 		return fmt.Errorf("cluster config is nil")
 	}
-	addr := c.config.NodeInfo(name)
+	addr := config.NodeInfo(name)
 	if addr == "" {
 		return fmt.Errorf("no address for node: %s", name)
 	}
-	c.gate[name] = gate.NewGate(
+	g := gate.NewGate(
 		gate.WithAddress(addr),
 		gate.WithAgent(c),
 	)
-	return c.gate[name].Start()
+	// 启动失败的 gate 没有 listener, 不能留给之后的 Reload 调用 Stop
+	if err := g.Start(); err != nil {
+		return err
+	}
+	c.gate[name] = g
+	return nil
 }
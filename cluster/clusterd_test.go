@@ -0,0 +1,324 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Zwlin98/moon/lua"
+	"github.com/Zwlin98/moon/service"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// testClusterd 返回监听本地端口的 Clusterd, 节点名为 self, 测试结束时关闭
+func testClusterd(t *testing.T) *skynetClusterd {
+	t.Helper()
+	c := newClusterd()
+	c.Reload(DefaultConfig{"self": freeAddr(t)})
+	if err := c.Open("self"); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	t.Cleanup(func() {
+		c.gate["self"].Stop()
+		c.nodeSender.Range(func(_, value any) bool {
+			value.(Sender).Exit()
+			return true
+		})
+	})
+	return c
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCallNamedService(t *testing.T) {
+	c := testClusterd(t)
+	if err := c.Register("ping", service.NewPingService()); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	handle, ok := c.queryName("ping")
+	if !ok || handle == 0 {
+		t.Fatalf("no address for ping")
+	}
+	client := c.fetchSender("self")
+	// skynet 的 cluster.call 和 cluster.proxy 使用 @name
+	for _, address := range []any{"ping", "@ping", handle} {
+		ret, err := client.Call(testContext(t), address, "ping", nil)
+		if err != nil {
+			t.Fatalf("call %v failed: %v", address, err)
+		}
+		lua.AssertEqual(t, lua.Table{Array: lua.Args("pong")}, lua.Table{Array: ret})
+	}
+	// cluster.query 发送到地址 0 的名字查询
+	ret, err := client.Call(testContext(t), uint32(0), "@ping", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if got, _ := lua.As[uint32](ret[0]); got != handle {
+		t.Errorf("query address not match: %v != %d", ret, handle)
+	}
+	if _, err := client.Call(testContext(t), "@missing", "ping", nil); err == nil {
+		t.Errorf("expected error for missing service")
+	}
+	if err := c.Register("@ping", service.NewPingService()); err == nil {
+		t.Errorf("expected duplicate registration error")
+	}
+}
+
+// lifecycleService 的 Execute 阻塞到 release 关闭, 记录 Close 时是否还有调用在执行
+type lifecycleService struct {
+	name    string
+	initErr error
+	started chan struct{}
+	release chan struct{}
+
+	running     atomic.Int32
+	inited      atomic.Bool
+	closed      atomic.Bool
+	closedEarly atomic.Bool
+}
+
+func newLifecycleService(name string) *lifecycleService {
+	return &lifecycleService{name: name, started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (s *lifecycleService) Init(ctx context.Context) error {
+	s.inited.Store(true)
+	return s.initErr
+}
+
+func (s *lifecycleService) Execute(args []lua.Value) ([]lua.Value, error) {
+	s.running.Add(1)
+	defer s.running.Add(-1)
+	s.started <- struct{}{}
+	<-s.release
+	return lua.Args(s.name), nil
+}
+
+func (s *lifecycleService) Close() error {
+	if s.running.Load() > 0 {
+		s.closedEarly.Store(true)
+	}
+	s.closed.Store(true)
+	return nil
+}
+
+// callAsync 在后台调用 self 节点上的服务, 返回结果的第一个值
+func callAsync(t *testing.T, client Sender, address any) chan string {
+	ch := make(chan string, 1)
+	go func() {
+		ret, err := client.Call(testContext(t), address, "call", nil)
+		if err != nil {
+			t.Errorf("call %v failed: %v", address, err)
+			ch <- ""
+			return
+		}
+		name, _ := lua.As[string](ret[0])
+		ch <- name
+	}()
+	return ch
+}
+
+func waitStarted(t *testing.T, s *lifecycleService) {
+	t.Helper()
+	select {
+	case <-s.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("service %s not called", s.name)
+	}
+}
+
+func TestUnregisterDrain(t *testing.T) {
+	c := testClusterd(t)
+	a := newLifecycleService("a")
+	if err := c.Register("svc", a); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if !a.inited.Load() {
+		t.Errorf("Init not called")
+	}
+	client := c.fetchSender("self")
+	ret := callAsync(t, client, "svc")
+	waitStarted(t, a)
+
+	done := make(chan error)
+	go func() { done <- c.Unregister("svc") }()
+	select {
+	case <-done:
+		t.Fatalf("Unregister returned before the call finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 注销开始后新的调用找不到服务
+	if c.Query("svc") != nil {
+		t.Errorf("service still visible during unregister")
+	}
+	if _, err := client.Call(testContext(t), "svc", "call", nil); err == nil {
+		t.Errorf("expected service not found")
+	}
+
+	close(a.release)
+	if name := <-ret; name != "a" {
+		t.Errorf("in-flight call not match: %q", name)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unregister failed: %v", err)
+	}
+	if !a.closed.Load() || a.closedEarly.Load() {
+		t.Errorf("Close should run after in-flight calls: closed=%v early=%v", a.closed.Load(), a.closedEarly.Load())
+	}
+	if err := c.Unregister("svc"); err == nil {
+		t.Errorf("expected error for unregistered service")
+	}
+
+	b := newLifecycleService("b")
+	b.initErr = errors.New("init failed")
+	if err := c.Register("svc", b); err == nil || c.Query("svc") != nil {
+		t.Errorf("service with failed Init should not be registered: %v", err)
+	}
+}
+
+func TestReplaceDrain(t *testing.T) {
+	c := testClusterd(t)
+	a, b := newLifecycleService("a"), newLifecycleService("b")
+	c.Register("svc", a)
+	handle, _ := c.queryName("svc")
+	client := c.fetchSender("self")
+
+	oldRet := callAsync(t, client, "svc")
+	waitStarted(t, a)
+	done := make(chan error)
+	go func() { done <- c.Replace("svc", b) }()
+
+	// Replace 立即替换服务, 之后新的调用使用 b, 名字和数字地址不变
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Query("svc") != service.Service(b) {
+		if time.Now().After(deadline) {
+			t.Fatalf("service not replaced")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(b.release)
+	for _, address := range []any{"svc", handle} {
+		if name := <-callAsync(t, client, address); name != "b" {
+			t.Errorf("call %v not served by new service: %q", address, name)
+		}
+	}
+	if h, _ := c.queryName("svc"); h != handle {
+		t.Errorf("address changed: %d != %d", h, handle)
+	}
+	select {
+	case <-done:
+		t.Fatalf("Replace returned before the old call finished")
+	default:
+	}
+	if a.closed.Load() {
+		t.Fatalf("old service closed during call")
+	}
+
+	close(a.release)
+	if name := <-oldRet; name != "a" {
+		t.Errorf("in-flight call not match: %q", name)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("replace failed: %v", err)
+	}
+	if !a.closed.Load() || a.closedEarly.Load() {
+		t.Errorf("old service should close after its calls: closed=%v early=%v", a.closed.Load(), a.closedEarly.Load())
+	}
+	if !b.inited.Load() || b.closed.Load() {
+		t.Errorf("new service state not match")
+	}
+	if err := c.Replace("missing", b); err == nil {
+		t.Errorf("expected error for missing service")
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	c := newClusterd()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("svc-%d-%d", i, j)
+				if err := c.Register(name, service.NewPingService()); err != nil {
+					t.Errorf("register failed: %v", err)
+					return
+				}
+				if j%2 == 0 {
+					c.Replace(name, service.NewPingService())
+				} else {
+					c.Unregister(name)
+				}
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("svc-%d-%d", i, j)
+				c.Query(name)
+				c.queryName(name)
+				svc, release := c.acquire(uint32(j + 1))
+				if svc != nil {
+					svc.Execute(lua.Args("ping"))
+				}
+				release()
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 每个协程注册的服务中一半被注销
+	count := 0
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 100; j++ {
+			if c.Query(fmt.Sprintf("svc-%d-%d", i, j)) != nil {
+				count++
+			}
+		}
+	}
+	if count != 400 {
+		t.Errorf("registered services not match: %d", count)
+	}
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	c := testClusterd(t)
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	var wg sync.WaitGroup
+	for i := range addrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// 自身地址变化时重新打开 gate
+				c.Reload(DefaultConfig{"self": addrs[(i+j)%len(addrs)]})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	config, _ := c.getConfig()
+	c.gateLock.Lock()
+	defer c.gateLock.Unlock()
+	if addr := c.gate["self"].Address(); addr != config.NodeInfo("self") {
+		t.Errorf("gate address not match: %s != %s", addr, config.NodeInfo("self"))
+	}
+}
@@ -1,10 +1,32 @@
 package cluster
 
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Zwlin98/moon/lua"
+)
+
 type ClusterConfig interface {
 	GetNodes() map[string]string
 	NodeInfo(string) string
 }
 
+// NodeStatusConfig 是 ClusterConfig 的可选接口, 与 skynet 的 clusterd 相同:
+// 1. 节点不在配置中时, 调用等待配置重新加载, NoWaiting 返回 true 时立即返回错误
+// 2. 节点配置为 false 时, 表示节点已经下线, 调用立即返回错误
+// 没有实现该接口的配置总是不等待
+type NodeStatusConfig interface {
+	NoWaiting() bool
+	NodeDown(string) bool
+}
+
 type DefaultConfig map[string]string
 
 func (c DefaultConfig) GetNodes() map[string]string {
@@ -14,3 +36,138 @@ func (c DefaultConfig) GetNodes() map[string]string {
 func (c DefaultConfig) NodeInfo(node string) string {
 	return c[node]
 }
+
+// 修改检查的间隔
+const DEFAULT_CONFIG_POLL = 2 * time.Second
+
+// LuaConfig 是 skynet 的 cluster 配置文件 (skynet config 中 cluster 项指向的文件), 例如:
+//
+//	__nowaiting = true
+//	db = "127.0.0.1:2528"
+//	db2 = false
+type LuaConfig struct {
+	nodes     map[string]string
+	down      map[string]bool
+	nowaiting bool
+}
+
+func ParseConfig(src string) (*LuaConfig, error) {
+	t, err := lua.ParseAssignments(src)
+	if err != nil {
+		return nil, err
+	}
+	c := &LuaConfig{
+		nodes: make(map[string]string),
+		down:  make(map[string]bool),
+	}
+	for k, v := range t.Hash {
+		name := lua.MustString(k)
+		if name == "__nowaiting" {
+			// 与 Lua 相同, false 以外的值都为真
+			b, ok := v.(lua.Boolean)
+			c.nowaiting = !ok || bool(b)
+			continue
+		}
+		// 与 skynet 相同, 其他 __ 开头的名字不是节点
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		switch addr := v.(type) {
+		case lua.String:
+			c.nodes[name] = string(addr)
+		case lua.Boolean:
+			if addr {
+				return nil, fmt.Errorf("invalid address for node %s: true", name)
+			}
+			c.down[name] = true
+		default:
+			return nil, fmt.Errorf("invalid address for node %s: %s", name, lua.Format(v))
+		}
+	}
+	return c, nil
+}
+
+func LoadConfigFile(path string) (*LuaConfig, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *LuaConfig) GetNodes() map[string]string {
+	return c.nodes
+}
+
+func (c *LuaConfig) NodeInfo(node string) string {
+	return c.nodes[node]
+}
+
+func (c *LuaConfig) NoWaiting() bool {
+	return c.nowaiting
+}
+
+func (c *LuaConfig) NodeDown(node string) bool {
+	return c.down[node]
+}
+
+type WatchOptions struct {
+	PollInterval time.Duration // 检查文件是否修改的间隔, 为零时使用 DEFAULT_CONFIG_POLL
+	OnReload     func(error)   // 每次重新加载后调用, 加载失败时参数为错误
+}
+
+// WatchConfigFile 加载配置文件并交给 clusterd.Reload, 之后在文件修改或收到 SIGHUP 时重新加载, 直到 ctx 结束.
+// 第一次加载失败时返回错误, 之后加载失败只记录日志并保留原来的配置
+func WatchConfigFile(ctx context.Context, clusterd Clusterd, path string) error {
+	return WatchConfigFileWithOptions(ctx, clusterd, path, WatchOptions{})
+}
+
+func WatchConfigFileWithOptions(ctx context.Context, clusterd Clusterd, path string, opts WatchOptions) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DEFAULT_CONFIG_POLL
+	}
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	stat, _ := os.Stat(path)
+	clusterd.Reload(config)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(opts.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				slog.Info("reload cluster config", "path", path, "reason", "SIGHUP")
+			case <-ticker.C:
+				newStat, err := os.Stat(path)
+				if err != nil || (stat != nil && newStat.ModTime().Equal(stat.ModTime()) && newStat.Size() == stat.Size()) {
+					continue
+				}
+				stat = newStat
+				slog.Info("reload cluster config", "path", path, "reason", "modified")
+			}
+			config, err := LoadConfigFile(path)
+			if err != nil {
+				slog.Error("reload cluster config failed", "path", path, "error", err)
+			} else {
+				clusterd.Reload(config)
+			}
+			if opts.OnReload != nil {
+				opts.OnReload(err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	cases := []struct {
+		src       string
+		nodes     map[string]string
+		down      []string
+		nowaiting bool
+		err       bool
+	}{
+		{
+			src:   `db = "127.0.0.1:2528"`,
+			nodes: map[string]string{"db": "127.0.0.1:2528"},
+		},
+		{
+			src: `
+-- skynet cluster config
+__nowaiting = true
+db = "127.0.0.1:2528"; db2 = false
+game = "127.0.0.1:2529"
+game = nil
+`,
+			nodes:     map[string]string{"db": "127.0.0.1:2528"},
+			down:      []string{"db2"},
+			nowaiting: true,
+		},
+		{src: `__nowaiting = false`, nodes: map[string]string{}},
+		{src: `__nowaiting = 1`, nodes: map[string]string{}, nowaiting: true},
+		{src: `__other = "x"`, nodes: map[string]string{}},
+		{src: `db = true`, err: true},
+		{src: `db = 2528`, err: true},
+		{src: `db = {"127.0.0.1", 2528}`, err: true},
+		{src: `db "127.0.0.1:2528"`, err: true},
+		{src: `db = "127.0.0.1:2528`, err: true},
+		{src: `db = require "cluster"`, err: true},
+	}
+	for _, cs := range cases {
+		c, err := ParseConfig(cs.src)
+		if cs.err {
+			if err == nil {
+				t.Errorf("parse %q should fail", cs.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse %q failed: %v", cs.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.GetNodes(), cs.nodes) {
+			t.Errorf("parse %q: nodes not match: %v", cs.src, c.GetNodes())
+		}
+		for _, name := range cs.down {
+			if !c.NodeDown(name) || c.NodeInfo(name) != "" {
+				t.Errorf("parse %q: node %s should be down", cs.src, name)
+			}
+		}
+		if c.NoWaiting() != cs.nowaiting {
+			t.Errorf("parse %q: nowaiting not match", cs.src)
+		}
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clustername.lua")
+	if err := os.WriteFile(path, []byte(`db = "127.0.0.1:2528"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newClusterd()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan error, 1)
+	opts := WatchOptions{
+		PollInterval: 10 * time.Millisecond,
+		OnReload:     func(err error) { reloads <- err },
+	}
+	waitReload := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("config not reloaded")
+			return nil
+		}
+	}
+	// 先写入临时文件再替换, 轮询不会读到写了一半的文件
+	replace := func(src string) {
+		t.Helper()
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WatchConfigFileWithOptions(ctx, c, path, opts); err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	config, _ := c.getConfig()
+	if config.NodeInfo("db") != "127.0.0.1:2528" {
+		t.Fatalf("config not loaded: %v", config.GetNodes())
+	}
+
+	// 语法错误时保留原来的配置
+	replace(`db = `)
+	if err := waitReload(); err == nil {
+		t.Fatalf("expected reload error")
+	}
+	if config, _ := c.getConfig(); config.NodeInfo("db") != "127.0.0.1:2528" {
+		t.Fatalf("config should be kept on error: %v", config.GetNodes())
+	}
+
+	replace("db = \"127.0.0.1:2530\"\ndb2 = false\n")
+	if err := waitReload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	config, _ = c.getConfig()
+	if config.NodeInfo("db") != "127.0.0.1:2530" || !config.(NodeStatusConfig).NodeDown("db2") {
+		t.Errorf("config not match: %v", config.GetNodes())
+	}
+	if _, err := c.waitSender(context.Background(), "db2"); err == nil {
+		t.Errorf("expected node down error")
+	}
+
+	if err := WatchConfigFile(ctx, c, filepath.Join(t.TempDir(), "missing.lua")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -1,5 +1,7 @@
 package cluster
 
+import "time"
+
 const (
 	MULTI_PART = 0x8000
 
@@ -18,6 +20,9 @@ const (
 	REQUEST_TRACE uint8 = 0x04
 
 	DEFAULT_BUFFER_SIZE = 0x8200
+
+	// Call 没有指定 context 时的默认超时
+	DEFAULT_CALL_TIMEOUT = 30 * time.Second
 )
 
 const (
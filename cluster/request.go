@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/Zwlin98/moon/lua"
 )
 
 type Request struct {
@@ -12,14 +14,41 @@ type Request struct {
 	IsPush  bool
 	Msg     []byte
 
+	// skynet.trace() 的标记, 发送时在请求前写入 trace 包
+	TraceTag string
+
 	Completed bool // for received request
+	IsTrace   bool // for received request, trace 包的 TraceTag 属于下一个请求
 }
 
 type PackedRequest struct {
+	Trace []byte
 	Data  []byte
 	Multi [][]byte
 }
 
+// PackTrace 打包 trace 包, 与 skynet cluster.packtrace 相同
+func PackTrace(tag string) ([]byte, error) {
+	if len(tag) > MULTI_PART {
+		return nil, fmt.Errorf("trace tag is too long")
+	}
+	buf := make([]byte, 0, 1+len(tag))
+	buf = append(buf, REQUEST_TRACE)
+	return append(buf, tag...), nil
+}
+
+func withTrace(pr PackedRequest, r Request) (PackedRequest, error) {
+	if r.TraceTag == "" {
+		return pr, nil
+	}
+	trace, err := PackTrace(r.TraceTag)
+	if err != nil {
+		return PackedRequest{}, err
+	}
+	pr.Trace = trace
+	return pr, nil
+}
+
 func PackRequest(r Request) (PackedRequest, error) {
 	var pr = PackedRequest{}
 	if r.Address == nil {
@@ -32,14 +61,23 @@ func PackRequest(r Request) (PackedRequest, error) {
 	if msgSize == 0 {
 		return pr, fmt.Errorf("msg is empty")
 	}
+	var err error
 	if msgSize < MULTI_PART {
-		return packSingleRequest(r)
+		pr, err = packSingleRequest(r)
+	} else {
+		pr, err = packMultiRequest(r)
+	}
+	if err != nil {
+		return PackedRequest{}, err
 	}
-	return packMultiRequest(r)
+	return withTrace(pr, r)
 }
 
 func UnpackRequest(data []byte) (Request, error) {
 	var r = Request{}
+	if len(data) == 0 {
+		return r, fmt.Errorf("request is empty")
+	}
 	switch data[0] {
 	case REQUEST_SINGLE_NUMBER:
 		fallthrough
@@ -57,6 +95,10 @@ func UnpackRequest(data []byte) (Request, error) {
 		fallthrough
 	case REQUEST_MULTI_PART_END:
 		return unpackMultiRequest(data)
+	case REQUEST_TRACE:
+		r.TraceTag = string(data[1:])
+		r.IsTrace = true
+		return r, nil
 	default:
 		return r, fmt.Errorf("request type is not supported")
 	}
@@ -114,9 +156,8 @@ func unpackMultiRequest(data []byte) (Request, error) {
 	return r, nil
 }
 
-func packSingleRequest(r Request) (PackedRequest, error) {
-	var pr = PackedRequest{}
-	var buf = bytes.NewBuffer(nil)
+// writeSingleRequestHeader 写入单包请求中消息之前的部分
+func writeSingleRequestHeader(buf *bytes.Buffer, r Request) error {
 	switch r.Address.(type) {
 	case uint32:
 		address := r.Address.(uint32)
@@ -124,19 +165,11 @@ func packSingleRequest(r Request) (PackedRequest, error) {
 		buf.WriteByte(REQUEST_SINGLE_NUMBER)
 		// 4 bytes for address
 		binary.Write(buf, binary.LittleEndian, address)
-		// 4 bytes for session
-		if !r.IsPush {
-			binary.Write(buf, binary.LittleEndian, r.Session)
-		} else {
-			binary.Write(buf, binary.LittleEndian, uint32(0))
-		}
-		// copy msg
-		buf.Write(r.Msg)
 	case string:
 		name := r.Address.(string)
 		nameLen := uint8(len(name))
 		if nameLen < 1 || nameLen > 255 {
-			return PackedRequest{}, fmt.Errorf("name length error")
+			return fmt.Errorf("name length error")
 		}
 		// 1 byte for request type
 		buf.WriteByte(REQUEST_SINGLE_STRING)
@@ -144,21 +177,63 @@ func packSingleRequest(r Request) (PackedRequest, error) {
 		buf.WriteByte(uint8(nameLen))
 		// `nameLen` bytes for name
 		buf.Write([]byte(name))
-		// 4 bytes for session
-		if !r.IsPush {
-			binary.Write(buf, binary.LittleEndian, r.Session)
-		} else {
-			binary.Write(buf, binary.LittleEndian, uint32(0))
-		}
-		// copy msg
-		buf.Write(r.Msg)
 	default:
-		return PackedRequest{}, fmt.Errorf("address type is not supported")
+		return fmt.Errorf("address type is not supported")
+	}
+	// 4 bytes for session
+	if !r.IsPush {
+		binary.Write(buf, binary.LittleEndian, r.Session)
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+	}
+	return nil
+}
+
+func packSingleRequest(r Request) (PackedRequest, error) {
+	var pr = PackedRequest{}
+	var buf = bytes.NewBuffer(nil)
+	if err := writeSingleRequestHeader(buf, r); err != nil {
+		return pr, err
 	}
+	// copy msg
+	buf.Write(r.Msg)
 	pr.Data = buf.Bytes()
 	return pr, nil
 }
 
+// PackRequestValues 与 PackRequest 相同, 消息为 values 序列化的结果.
+// 单包请求直接序列化到请求的缓冲区中, 不需要再复制一次
+func PackRequestValues(r Request, values []lua.Value) (PackedRequest, error) {
+	size := lua.SerializedSize(values)
+	if size < 0 || size >= MULTI_PART {
+		msg, err := lua.AppendSerialize(make([]byte, 0, max(size, 0)), values)
+		if err != nil {
+			return PackedRequest{}, err
+		}
+		r.Msg = msg
+		return PackRequest(r)
+	}
+	if r.Address == nil {
+		return PackedRequest{}, fmt.Errorf("address is nil")
+	}
+	if r.Session == 0 {
+		return PackedRequest{}, fmt.Errorf("session is zero")
+	}
+	if size == 0 {
+		return PackedRequest{}, fmt.Errorf("msg is empty")
+	}
+	// 1 byte for type, 1 byte for name length, at most 255 bytes for name, 4 bytes for session
+	buf := bytes.NewBuffer(make([]byte, 0, 261+size))
+	if err := writeSingleRequestHeader(buf, r); err != nil {
+		return PackedRequest{}, err
+	}
+	data, err := lua.AppendSerialize(buf.Bytes(), values)
+	if err != nil {
+		return PackedRequest{}, err
+	}
+	return withTrace(PackedRequest{Data: data}, r)
+}
+
 func packMultiRequest(r Request) (PackedRequest, error) {
 	var pr = PackedRequest{
 		Multi: make([][]byte, 0),
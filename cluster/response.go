@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/Zwlin98/moon/lua"
 )
 
 type Response struct {
@@ -30,6 +32,33 @@ func PackResponse(r Response) (PackedResponse, error) {
 	return packSingleResponse(r)
 }
 
+// PackResponseValues 与 PackResponse 相同, 消息为 values 序列化的结果.
+// 单包回应直接序列化到回应的缓冲区中, 不需要再复制一次
+func PackResponseValues(r Response, values []lua.Value) (PackedResponse, error) {
+	size := lua.SerializedSize(values)
+	if size < 0 || size > MULTI_PART {
+		msg, err := lua.AppendSerialize(make([]byte, 0, max(size, 0)), values)
+		if err != nil {
+			return PackedResponse{}, err
+		}
+		r.Msg = msg
+		return PackResponse(r)
+	}
+	// 4 bytes for session, 1 byte for response type
+	data := make([]byte, 5, 5+size)
+	binary.LittleEndian.PutUint32(data, r.Session)
+	if r.Ok {
+		data[4] = RESPONSE_OK
+	} else {
+		data[4] = RESPONSE_ERROR
+	}
+	data, err := lua.AppendSerialize(data, values)
+	if err != nil {
+		return PackedResponse{}, err
+	}
+	return PackedResponse{Data: data}, nil
+}
+
 func UnpackResponse(data []byte) (Response, error) {
 	if len(data) < 5 {
 		return Response{}, fmt.Errorf("response data is too short")
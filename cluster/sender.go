@@ -1,108 +1,261 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Zwlin98/moon/gate"
 	"github.com/Zwlin98/moon/lua"
+	"github.com/Zwlin98/moon/service"
 )
 
 // call/send other skynet node
 type Sender interface {
 	RemoteAddr() string
+	State() SenderState
 
-	Call(string, string, []lua.Value) ([]lua.Value, error)
-	Send(string, string, []lua.Value) error
+	Call(context.Context, any, string, []lua.Value) ([]lua.Value, error)
+	Send(context.Context, any, string, []lua.Value) error
 
 	Start()
 	Exit()
 }
 
+// SenderState 是到其他节点的连接状态
+type SenderState uint32
+
+const (
+	SENDER_CONNECTING SenderState = iota
+	SENDER_READY
+	SENDER_BACKING_OFF
+	SENDER_EXITED
+)
+
+func (s SenderState) String() string {
+	switch s {
+	case SENDER_CONNECTING:
+		return "connecting"
+	case SENDER_READY:
+		return "ready"
+	case SENDER_BACKING_OFF:
+		return "backing-off"
+	default:
+		return "exited"
+	}
+}
+
+// 与 skynet clustersender 相同, 连接断开时请求在队列中等待重连, 已发出的调用返回错误
+const (
+	DEFAULT_QUEUE_SIZE   = 1024
+	DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+	DEFAULT_BACKOFF_MIN  = 100 * time.Millisecond
+	DEFAULT_BACKOFF_MAX  = 10 * time.Second
+)
+
+type queuedRequest struct {
+	session uint32
+	isPush  bool
+	packed  PackedRequest
+}
+
+type pendingCall struct {
+	resp chan Response
+	conn atomic.Uint64 // 发出请求的连接序号, 0 表示还在队列中
+}
+
 type skynetSender struct {
 	clusterd   Clusterd
 	remoteName string
 	remoteAddr string
-	conn       net.Conn
 
 	session uint32
+	state   atomic.Uint32
+	connSeq uint64
 
 	pendingResponse map[uint32]Response
 	pendingRespChan sync.Map
 
-	reqChan chan PackedRequest
-	exit    chan struct{}
+	reqChan  chan queuedRequest
+	exit     chan struct{}
+	exitOnce sync.Once
 }
 
-func NewClusterClient(clusterd Clusterd, name string, addr string) (Sender, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-
-	client := skynetSender{
+// NewClusterClient 创建到 addr 的连接, Start 之后在后台连接并在断开时自动重连
+func NewClusterClient(clusterd Clusterd, name string, addr string) Sender {
+	return &skynetSender{
 		clusterd:        clusterd,
 		remoteName:      name,
 		remoteAddr:      addr,
-		conn:            conn,
 		session:         1,
-		reqChan:         make(chan PackedRequest),
+		reqChan:         make(chan queuedRequest, DEFAULT_QUEUE_SIZE),
 		pendingResponse: make(map[uint32]Response),
 		exit:            make(chan struct{}),
 	}
-
-	return &client, nil
 }
 
 func (sc *skynetSender) Start() {
-	proto := gate.NewGateProto(sc.conn, sc.conn)
+	go sc.run()
+}
+
+func (sc *skynetSender) setState(s SenderState) {
+	sc.state.Store(uint32(s))
+}
+
+func (sc *skynetSender) State() SenderState {
+	return SenderState(sc.state.Load())
+}
+
+// run 连接失败或断开后按指数退避重连, 直到 Exit
+func (sc *skynetSender) run() {
+	// 连接过程中 Exit 时, 不能留下 CONNECTING 或 READY 状态
+	defer sc.setState(SENDER_EXITED)
+	backoff := DEFAULT_BACKOFF_MIN
+	for {
+		sc.setState(SENDER_CONNECTING)
+		conn, err := net.DialTimeout("tcp", sc.remoteAddr, DEFAULT_DIAL_TIMEOUT)
+		if err == nil {
+			select {
+			case <-sc.exit:
+				conn.Close()
+				return
+			default:
+			}
+			slog.Info("ClusterClient connected", "name", sc.name(), "addr", sc.remoteAddr)
+			backoff = DEFAULT_BACKOFF_MIN
+			sc.setState(SENDER_READY)
+			sc.serve(conn)
+		} else {
+			slog.Error("ClusterClient connect failed", "name", sc.name(), "addr", sc.remoteAddr, "error", err)
+		}
+
+		select {
+		case <-sc.exit:
+			return
+		default:
+		}
 
+		// 在 [backoff/2, backoff) 之间随机等待, 避免多个节点同时重连
+		delay := backoff/2 + time.Duration(rand.Int64N(int64(backoff/2)+1))
+		backoff = min(backoff*2, DEFAULT_BACKOFF_MAX)
+		sc.setState(SENDER_BACKING_OFF)
+		timer := time.NewTimer(delay)
+		select {
+		case <-sc.exit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// serve 在连接上发送队列中的请求, 连接断开或 Exit 时返回
+func (sc *skynetSender) serve(conn net.Conn) {
+	proto := gate.NewGateProto(conn, conn)
+	// 上一个连接的读协程已经退出, 未完成的多包回应不会再到达
+	sc.pendingResponse = make(map[uint32]Response)
+	sc.connSeq++
+	seq := sc.connSeq
+
+	lost := make(chan struct{})
 	go func() {
+		defer close(lost)
 		for {
 			msg, err := proto.Read()
 			if err != nil {
-				slog.Error("ClusterClient read error", "addr", sc.conn.RemoteAddr(), "error", err)
-				sc.Exit()
+				slog.Error("ClusterClient read error", "addr", conn.RemoteAddr(), "error", err)
 				return
 			}
 			sc.dispatch(msg)
 		}
 	}()
 
-	go func() {
-		for {
-			select {
-			case <-sc.exit:
-				slog.Info("ClusterClient exited", "name", sc.name())
-				return
-			case req := <-sc.reqChan:
-				err := proto.Write(req.Data)
-				if err != nil {
-					slog.Error("ClusterClient failed to write message", "name", sc.name(), "error", err)
-					sc.Exit()
-					return
-				}
-				err = proto.WriteBatch(req.Multi)
-				if err != nil {
-					slog.Error("ClusterClient failed to write batch message", "name", sc.name(), "error", err)
-					sc.Exit()
-					return
+	defer func() {
+		conn.Close()
+		<-lost
+		// 已经在这个连接上发出的调用不会再收到回应, 队列中的请求等待重连后发送
+		sc.pendingRespChan.Range(func(key, value any) bool {
+			if value.(*pendingCall).conn.Load() == seq {
+				sc.callError(key.(uint32), fmt.Sprintf("ClusterClient %s connection lost", sc.name()))
+			}
+			return true
+		})
+	}()
+
+	for {
+		select {
+		case <-sc.exit:
+			return
+		case <-lost:
+			return
+		case req := <-sc.reqChan:
+			if !req.isPush {
+				pc, ok := sc.pendingRespChan.Load(req.session)
+				// 在队列中等待时已经超时或取消的调用不再发送
+				if !ok {
+					continue
 				}
+				pc.(*pendingCall).conn.Store(seq)
+			}
+			if err := sc.write(proto, req.packed); err != nil {
+				slog.Error("ClusterClient failed to write message", "name", sc.name(), "error", err)
+				return
 			}
 		}
-	}()
+	}
+}
+
+func (sc *skynetSender) write(proto gate.GateProto, req PackedRequest) error {
+	if req.Trace != nil {
+		if err := proto.Write(req.Trace); err != nil {
+			return err
+		}
+	}
+	if err := proto.Write(req.Data); err != nil {
+		return err
+	}
+	return proto.WriteBatch(req.Multi)
+}
+
+// enqueue 将请求放入发送队列. 连接正常时队列满则等待发送, 直到 ctx 结束;
+// 正在连接或等待重连时队列满立即返回错误
+func (sc *skynetSender) enqueue(ctx context.Context, req queuedRequest) error {
+	select {
+	case <-sc.exit:
+		return fmt.Errorf("ClusterClient %s is exited", sc.name())
+	default:
+	}
+	select {
+	case sc.reqChan <- req:
+		return nil
+	default:
+	}
+	if state := sc.State(); state != SENDER_READY {
+		return fmt.Errorf("ClusterClient %s request queue is full (%s)", sc.name(), state)
+	}
+	select {
+	case sc.reqChan <- req:
+		return nil
+	case <-sc.exit:
+		return fmt.Errorf("ClusterClient %s is exited", sc.name())
+	case <-ctx.Done():
+		return fmt.Errorf("ClusterClient %s request queue is full: %w", sc.name(), ctx.Err())
+	}
 }
 
 func (sc *skynetSender) callRet(resp Response) {
 	session := resp.Session
-	retChan, ok := sc.pendingRespChan.Load(session)
+	// 调用超时或取消后会删除 pendingRespChan, 之后到达的回应直接丢弃
+	pc, ok := sc.pendingRespChan.LoadAndDelete(session)
 	if ok {
-		retChan.(chan Response) <- resp
+		pc.(*pendingCall).resp <- resp
 	} else {
-		slog.Error("ClusterClient callRet failed, no pending response", "session", session, "name", sc.name())
+		slog.Warn("ClusterClient discard response, no pending call", "session", session, "name", sc.name())
 	}
 }
 
@@ -114,51 +267,56 @@ func (sc *skynetSender) callError(session uint32, msg string) {
 	})
 }
 
-func (sc *skynetSender) packCall(service string, method string, args []lua.Value, isPush bool) (PackedRequest, uint32, error) {
-	realArgs := []lua.Value{lua.String(method)}
-	realArgs = append(realArgs, args...)
-
-	packedArgs, err := lua.Serialize(realArgs)
+func (sc *skynetSender) packCall(ctx context.Context, address any, method string, args []lua.Value, isPush bool) (queuedRequest, error) {
+	realArgs, err := lua.TryArgs(method)
 	if err != nil {
-		return PackedRequest{}, 0, err
+		return queuedRequest{}, err
 	}
+	realArgs = append(realArgs, args...)
 
 	session := atomic.AddUint32(&sc.session, 1)
 
 	req := Request{
-		Address: service,
+		Address: address,
 		Session: session,
 		IsPush:  isPush,
-		Msg:     packedArgs,
+	}
+	if tag, ok := service.TraceTag(ctx); ok {
+		req.TraceTag = tag
 	}
 
-	packedReq, err := PackRequest(req)
+	packedReq, err := PackRequestValues(req, realArgs)
 
-	return packedReq, session, err
+	return queuedRequest{session: session, isPush: isPush, packed: packedReq}, err
 }
 
 // Call implements Client.
-func (sc *skynetSender) Call(service string, method string, args []lua.Value) ([]lua.Value, error) {
-	packReq, session, err := sc.packCall(service, method, args, false)
+func (sc *skynetSender) Call(ctx context.Context, address any, method string, args []lua.Value) ([]lua.Value, error) {
+	req, err := sc.packCall(ctx, address, method, args, false)
 
 	if err != nil {
 		return nil, err
 	}
+	session := req.session
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("session %d, call %s %v: %w", session, sc.name(), address, err)
+	}
 
-	respChan := make(chan Response)
-	sc.pendingRespChan.Store(session, respChan)
+	// 有缓冲, 调用方放弃等待后 callRet 也不会阻塞
+	pc := &pendingCall{resp: make(chan Response, 1)}
+	sc.pendingRespChan.Store(session, pc)
 	defer sc.pendingRespChan.Delete(session)
 
-	select {
-	case <-sc.exit:
-		return nil, fmt.Errorf("ClusterClient %s is exited [CallOut]", sc.name())
-	case sc.reqChan <- packReq:
+	if err := sc.enqueue(ctx, req); err != nil {
+		return nil, err
 	}
 
 	select {
 	case <-sc.exit:
 		return nil, fmt.Errorf("session %d, ClusterClient %s is exited [Waiting CallRet]", session, sc.name())
-	case resp := <-respChan:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("session %d, call %s %v (%s): %w", session, sc.name(), address, sc.State(), ctx.Err())
+	case resp := <-pc.resp:
 		if resp.Ok {
 			return lua.Deserialize(resp.Msg)
 		} else {
@@ -167,24 +325,24 @@ func (sc *skynetSender) Call(service string, method string, args []lua.Value) ([
 	}
 }
 
-func (sc *skynetSender) Send(service string, method string, args []lua.Value) error {
-	packReq, _, err := sc.packCall(service, method, args, true)
+func (sc *skynetSender) Send(ctx context.Context, address any, method string, args []lua.Value) error {
+	req, err := sc.packCall(ctx, address, method, args, true)
 	if err != nil {
 		return err
 	}
-	select {
-	case <-sc.exit:
-		return fmt.Errorf("ClusterClient %s is exited [SendOut]", sc.name())
-	case sc.reqChan <- packReq:
-		return nil
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("send %s %v: %w", sc.name(), address, err)
 	}
+	return sc.enqueue(ctx, req)
 }
 
 func (sc *skynetSender) Exit() {
-	slog.Info("ClusterClient exit", "name", sc.name())
-	close(sc.exit)
-	sc.conn.Close()
-	sc.clusterd.OnSenderExit(sc.name())
+	sc.exitOnce.Do(func() {
+		slog.Info("ClusterClient exit", "name", sc.name())
+		sc.setState(SENDER_EXITED)
+		close(sc.exit)
+		sc.clusterd.OnSenderExit(sc.name())
+	})
 }
 
 func (sc *skynetSender) RemoteAddr() string {
@@ -214,6 +372,11 @@ func (sc *skynetSender) dispatch(msg []byte) {
 		}
 	case RESPONSE_MULTI_END:
 		prevResp, ok := sc.pendingResponse[resp.Session]
+		if !ok && !resp.Ok {
+			// 错误回应也以 RESPONSE_MULTI_END 结束, 没有 RESPONSE_MULTI_BEGIN 时是单包的错误回应
+			sc.callRet(resp)
+			return
+		}
 		if !ok {
 			slog.Warn("unexpected multi end response")
 			sc.callError(resp.Session, "unexpected multi end response")
@@ -0,0 +1,419 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zwlin98/moon/gate"
+	"github.com/Zwlin98/moon/lua"
+)
+
+// fakeNode 是测试用的 skynet 节点, 收到的请求不自动回应, 由测试调用 reply
+type fakeNode struct {
+	addr string
+	reqs chan fakeRequest
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+}
+
+type fakeRequest struct {
+	Request
+	args  []lua.Value
+	proto gate.GateProto
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	t.Helper()
+	n := &fakeNode{addr: "127.0.0.1:0", reqs: make(chan fakeRequest, 16)}
+	n.restart(t)
+	t.Cleanup(n.stop)
+	return n
+}
+
+// restart 在原来的地址上重新监听
+func (n *fakeNode) restart(t *testing.T) {
+	t.Helper()
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	n.mu.Lock()
+	n.addr = l.Addr().String()
+	n.listener = l
+	n.mu.Unlock()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			n.conns = append(n.conns, conn)
+			n.mu.Unlock()
+			go n.serve(conn)
+		}
+	}()
+}
+
+// stop 关闭监听以及所有连接, 模拟节点重启
+func (n *fakeNode) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.listener.Close()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+}
+
+// serve 只处理单包请求, 忽略 trace 包
+func (n *fakeNode) serve(conn net.Conn) {
+	defer conn.Close()
+	proto := gate.NewGateProto(conn, conn)
+	for {
+		msg, err := proto.Read()
+		if err != nil {
+			return
+		}
+		req, err := UnpackRequest(msg)
+		if err != nil || req.IsTrace {
+			continue
+		}
+		args, _ := lua.Deserialize(req.Msg)
+		n.reqs <- fakeRequest{Request: req, args: args, proto: proto}
+	}
+}
+
+func (n *fakeNode) next(t *testing.T) fakeRequest {
+	t.Helper()
+	select {
+	case req := <-n.reqs:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no request received")
+		return fakeRequest{}
+	}
+}
+
+func (n *fakeNode) reply(t *testing.T, req fakeRequest, values ...any) {
+	t.Helper()
+	packed, err := PackResponseValues(Response{Ok: true, Session: req.Session}, lua.Args(values...))
+	if err != nil {
+		t.Fatalf("pack response failed: %v", err)
+	}
+	if err := req.proto.Write(packed.Data); err != nil {
+		t.Fatalf("write response failed: %v", err)
+	}
+}
+
+func method(req fakeRequest) string {
+	s, _ := lua.As[string](req.args[0])
+	return s
+}
+
+func pendingCalls(client Sender) int {
+	count := 0
+	client.(*skynetSender).pendingRespChan.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func TestCallDefaultTimeout(t *testing.T) {
+	node := newFakeNode(t)
+	c := GetClusterd().(*skynetClusterd)
+	c.Reload(DefaultConfig{"timeout": node.addr})
+	SetCallTimeout(100 * time.Millisecond)
+	defer SetCallTimeout(DEFAULT_CALL_TIMEOUT)
+
+	start := time.Now()
+	_, err := Call("timeout", "svc", "slow", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timeout took too long: %v", elapsed)
+	}
+	client := c.fetchSender("timeout")
+	if n := pendingCalls(client); n != 0 {
+		t.Errorf("pending call not cleared: %d", n)
+	}
+
+	// 超时后到达的回应被丢弃, 不会交给之后的调用
+	late := node.next(t)
+	node.reply(t, late, "late")
+	done := make(chan []lua.Value)
+	go func() {
+		ret, err := Call("timeout", "svc", "fast", nil)
+		if err != nil {
+			t.Errorf("call failed: %v", err)
+		}
+		done <- ret
+	}()
+	req := node.next(t)
+	if method(req) != "fast" {
+		t.Fatalf("unexpected request: %v", req.args)
+	}
+	node.reply(t, req, "fast")
+	lua.AssertEqual(t, lua.Table{Array: lua.Args("fast")}, lua.Table{Array: <-done})
+}
+
+func TestCallCancel(t *testing.T) {
+	node := newFakeNode(t)
+	c := newClusterd()
+	c.Reload(DefaultConfig{"node": node.addr})
+	client := c.fetchSender("node")
+	defer client.Exit()
+
+	// 已经取消的 ctx 不发送请求
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Call(cancelled, "svc", "cancelled", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	if err := client.Send(cancelled, "svc", "cancelled", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		_, err := client.Call(ctx, "svc", "wait", nil)
+		errCh <- err
+	}()
+	req := node.next(t)
+	if method(req) != "wait" {
+		t.Fatalf("cancelled request was sent: %v", req.args)
+	}
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	if n := pendingCalls(client); n != 0 {
+		t.Errorf("pending call not cleared: %d", n)
+	}
+	node.reply(t, req, "late")
+
+	go func() {
+		_, err := client.Call(testContext(t), "svc", "after", nil)
+		errCh <- err
+	}()
+	req = node.next(t)
+	node.reply(t, req, "ok")
+	if err := <-errCh; err != nil {
+		t.Errorf("call after cancel failed: %v", err)
+	}
+}
+
+func waitState(t *testing.T, client Sender, state SenderState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("state not reached: %s, current %s", state, client.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCallErrorResponse(t *testing.T) {
+	node := newFakeNode(t)
+	c := newClusterd()
+	c.Reload(DefaultConfig{"node": node.addr})
+	client := c.fetchSender("node")
+	defer client.Exit()
+
+	errCh := make(chan error)
+	go func() {
+		_, err := client.Call(testContext(t), "svc", "fail", nil)
+		errCh <- err
+	}()
+	// 单包的错误回应
+	req := node.next(t)
+	packed, err := PackResponse(Response{Ok: false, Session: req.Session, Msg: []byte("boom")})
+	if err != nil {
+		t.Fatalf("pack response failed: %v", err)
+	}
+	if err := req.proto.Write(packed.Data); err != nil {
+		t.Fatalf("write response failed: %v", err)
+	}
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected remote error, got %v", err)
+	}
+
+	go func() {
+		_, err := client.Call(testContext(t), "svc", "after", nil)
+		errCh <- err
+	}()
+	node.reply(t, node.next(t), "ok")
+	if err := <-errCh; err != nil {
+		t.Errorf("call after error failed: %v", err)
+	}
+}
+
+func TestSenderReconnect(t *testing.T) {
+	node := newFakeNode(t)
+	c := newClusterd()
+	c.Reload(DefaultConfig{"node": node.addr})
+	client := c.fetchSender("node")
+	defer client.Exit()
+
+	inflight := make(chan error)
+	go func() {
+		_, err := client.Call(testContext(t), "svc", "inflight", nil)
+		inflight <- err
+	}()
+	if req := node.next(t); method(req) != "inflight" {
+		t.Fatalf("unexpected request: %v", req.args)
+	}
+	if state := c.NodeStates()["node"]; state != SENDER_READY {
+		t.Errorf("expected ready, got %s", state)
+	}
+
+	// 连接断开时已经发出的调用返回错误
+	node.stop()
+	if err := <-inflight; err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Fatalf("expected connection lost, got %v", err)
+	}
+	waitState(t, client, SENDER_BACKING_OFF)
+	if state := c.NodeStates()["node"]; state != SENDER_BACKING_OFF {
+		t.Errorf("expected backing-off, got %s", state)
+	}
+
+	// 在队列中超时的调用不会在重连后发送
+	expired, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(expired, "svc", "expired", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	queued := make(chan []lua.Value)
+	go func() {
+		ret, err := client.Call(testContext(t), "svc", "queued", nil)
+		if err != nil {
+			t.Errorf("queued call failed: %v", err)
+		}
+		queued <- ret
+	}()
+	waitPending(t, client, 1)
+	if err := client.Send(testContext(t), "svc", "push", nil); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	node.restart(t)
+	req := node.next(t)
+	if method(req) != "queued" {
+		t.Fatalf("expected queued call first, got %v", req.args)
+	}
+	node.reply(t, req, "ok")
+	lua.AssertEqual(t, lua.Table{Array: lua.Args("ok")}, lua.Table{Array: <-queued})
+	if req := node.next(t); method(req) != "push" || !req.IsPush {
+		t.Fatalf("expected push, got %v", req.args)
+	}
+	select {
+	case req := <-node.reqs:
+		t.Errorf("unexpected request: %v", req.args)
+	case <-time.After(50 * time.Millisecond):
+	}
+	waitState(t, client, SENDER_READY)
+}
+
+func waitPending(t *testing.T, client Sender, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for pendingCalls(client) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("pending calls not match: %d != %d", pendingCalls(client), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSenderQueueFull(t *testing.T) {
+	c := newClusterd()
+	c.Reload(DefaultConfig{"down": freeAddr(t)})
+	client := c.fetchSender("down")
+	defer client.Exit()
+
+	for i := 0; i < DEFAULT_QUEUE_SIZE; i++ {
+		if err := client.Send(context.Background(), "svc", "push", nil); err != nil {
+			t.Fatalf("send %d failed: %v", i, err)
+		}
+	}
+	err := client.Send(context.Background(), "svc", "push", nil)
+	if err == nil || !strings.Contains(err.Error(), "queue is full") {
+		t.Errorf("expected queue full error, got %v", err)
+	}
+	if _, err := client.Call(context.Background(), "svc", "call", nil); err == nil {
+		t.Errorf("expected queue full error for call")
+	}
+	if n := pendingCalls(client); n != 0 {
+		t.Errorf("pending call not cleared: %d", n)
+	}
+}
+
+func TestSenderBackpressure(t *testing.T) {
+	c := newClusterd()
+	// 不调用 Start, 由测试控制状态和队列
+	client := NewClusterClient(c, "node", freeAddr(t)).(*skynetSender)
+	client.reqChan = make(chan queuedRequest, 1)
+	defer client.Exit()
+
+	client.setState(SENDER_READY)
+	if err := client.Send(context.Background(), "svc", "first", nil); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	// 连接正常时等待队列空出
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Send(ctx, "svc", "timeout", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	errCh := make(chan error)
+	go func() {
+		errCh <- client.Send(testContext(t), "svc", "second", nil)
+	}()
+	<-client.reqChan
+	if err := <-errCh; err != nil {
+		t.Fatalf("send after drain failed: %v", err)
+	}
+
+	// 等待重连时立即返回
+	client.setState(SENDER_BACKING_OFF)
+	err := client.Send(testContext(t), "svc", "third", nil)
+	if err == nil || !strings.Contains(err.Error(), "queue is full") {
+		t.Errorf("expected queue full error, got %v", err)
+	}
+}
+
+func TestSenderExitWhileConnecting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	c := newClusterd()
+	client := NewClusterClient(c, "node", l.Addr().String())
+	client.Exit()
+	client.Start()
+
+	// 连接建立后发现已经 Exit, 直接关闭连接
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("connection not closed")
+	}
+	waitState(t, client, SENDER_EXITED)
+}
@@ -1,6 +1,7 @@
 package gate
 
 import (
+	"errors"
 	"log/slog"
 	"net"
 	"sync/atomic"
@@ -64,6 +65,11 @@ func (g *skynetGate) listenLoop() {
 	for {
 		conn, err := g.listener.Accept()
 		if err != nil {
+			// Stop 关闭了 listener
+			if errors.Is(err, net.ErrClosed) {
+				slog.Info("gate stopped", "address", g.address)
+				return
+			}
 			slog.Error("failed to accept new client", "error", err.Error())
 			continue
 		}
@@ -0,0 +1,92 @@
+package lua
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func benchmarkPayload() []Value {
+	rows := make([]Value, 0, 64)
+	for i := 0; i < 64; i++ {
+		rows = append(rows, Table{
+			Array: []Value{Integer(i), String("row-" + strconv.Itoa(i)), Real(float64(i) / 3)},
+		})
+	}
+	return []Value{
+		String("request"),
+		String("http://example.com/api/v1/items"),
+		Table{
+			Array: rows,
+			Hash: map[Value]Value{
+				String("method"):  String("POST"),
+				String("headers"): Table{Hash: map[Value]Value{String("Content-Type"): String("application/json")}},
+				String("body"):    String(string(make([]byte, 4096))),
+				String("noBody"):  Boolean(false),
+			},
+		},
+	}
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	values := benchmarkPayload()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Serialize(values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendSerialize(b *testing.B) {
+	values := benchmarkPayload()
+	buf := make([]byte, 0, SerializedSize(values))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := AppendSerialize(buf[:0], values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeserialize(b *testing.B) {
+	packed, _ := Serialize(benchmarkPayload())
+	b.SetBytes(int64(len(packed)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Deserialize(packed); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeserializeZeroCopy(b *testing.B) {
+	packed, _ := Serialize(benchmarkPayload())
+	opts := DecodeOptions{ZeroCopy: true}
+	b.SetBytes(int64(len(packed)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DeserializeWithOptions(packed, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	packed, _ := Serialize(benchmarkPayload())
+	b.SetBytes(int64(len(packed)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dec := NewDecoder(bytes.NewReader(packed))
+		for {
+			_, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -29,4 +29,6 @@ const (
 	LUA_REAL    uint8 = 3
 	LUA_STRING  uint8 = 4
 	LUA_TABLE   uint8 = 5
+
+	LUA_LIGHTUSERDATA uint8 = 6
 )
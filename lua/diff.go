@@ -0,0 +1,155 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Diff 逐层比较两个对象, 以 Lua 语法的路径报告新增, 删除和修改的项, 例如:
+//
+//	opts.headers["Content-Type"]: changed "text/plain" -> "text/html"
+//	[2]: removed 3
+
+type DiffKind uint8
+
+const (
+	DIFF_ADDED DiffKind = iota
+	DIFF_REMOVED
+	DIFF_CHANGED
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DIFF_ADDED:
+		return "added"
+	case DIFF_REMOVED:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+type Difference struct {
+	Path string
+	Kind DiffKind
+	A    Value // DIFF_ADDED 时为 nil
+	B    Value // DIFF_REMOVED 时为 nil
+}
+
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch d.Kind {
+	case DIFF_ADDED:
+		return fmt.Sprintf("%s: added %s", path, Format(d.B))
+	case DIFF_REMOVED:
+		return fmt.Sprintf("%s: removed %s", path, Format(d.A))
+	}
+	return fmt.Sprintf("%s: changed %s -> %s", path, Format(d.A), Format(d.B))
+}
+
+type DiffOptions struct {
+	// 数值相等的 Integer 与 Real 视为相同, 例如 1 与 1.0
+	NumberEqual bool
+}
+
+// Diff 严格比较 a 与 b, Integer 与 Real 不相同, Real 按位比较
+func Diff(a, b Value) []Difference {
+	return DiffWithOptions(a, b, DiffOptions{})
+}
+
+func DiffWithOptions(a, b Value, opts DiffOptions) []Difference {
+	d := differ{opts: opts}
+	d.diff("", a, b, 0)
+	return d.diffs
+}
+
+type differ struct {
+	opts  DiffOptions
+	diffs []Difference
+}
+
+func (d *differ) add(path string, kind DiffKind, a, b Value) {
+	d.diffs = append(d.diffs, Difference{Path: path, Kind: kind, A: a, B: b})
+}
+
+func (d *differ) diff(path string, a, b Value, depth int) {
+	ta, aok := asTable(a)
+	tb, bok := asTable(b)
+	if !aok || !bok || depth >= maxFormatDepth {
+		if !d.scalarEqual(a, b) {
+			d.add(path, DIFF_CHANGED, a, b)
+		}
+		return
+	}
+	// 与 Lua 相同, 键的比较不区分数组部分与哈希部分, 整数值的浮点数键等同于整数键
+	ta, tb = Normalize(ta), Normalize(tb)
+	keys := make(map[Value]Value, len(ta.Array)+len(ta.Hash))
+	collect := func(k, _ Value) bool {
+		keys[k] = nil
+		return true
+	}
+	ta.Pairs(collect)
+	tb.Pairs(collect)
+	for _, k := range sortedKeys(keys) {
+		keyPath := diffPath(path, k)
+		av, aok := ta.Get(k)
+		bv, bok := tb.Get(k)
+		switch {
+		case !bok:
+			d.add(keyPath, DIFF_REMOVED, av, nil)
+		case !aok:
+			d.add(keyPath, DIFF_ADDED, nil, bv)
+		default:
+			d.diff(keyPath, av, bv, depth+1)
+		}
+	}
+}
+
+func (d *differ) scalarEqual(a, b Value) bool {
+	if d.opts.NumberEqual {
+		if an, ok := toNumber(a); ok {
+			bn, ok := toNumber(b)
+			return ok && (an == bn || (math.IsNaN(an) && math.IsNaN(bn)))
+		}
+	}
+	return Equal(a, b)
+}
+
+func toNumber(v Value) (float64, bool) {
+	switch n := v.(type) {
+	case Integer:
+		return float64(n), true
+	case Real:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func diffPath(path string, k Value) string {
+	if s, ok := k.(String); ok {
+		return joinField(path, string(s))
+	}
+	return joinKey(path, k)
+}
+
+// AssertEqual 比较 want 与 got, 不相同时通过 t.Errorf 输出所有差异, t 通常是 *testing.T
+func AssertEqual(t interface {
+	Helper()
+	Errorf(format string, args ...any)
+}, want, got Value) bool {
+	t.Helper()
+	diffs := Diff(want, got)
+	if len(diffs) == 0 {
+		return true
+	}
+	msgs := make([]string, len(diffs))
+	for i, d := range diffs {
+		msgs[i] = "\t" + d.String()
+	}
+	t.Errorf("value not match (want -> got):\n%s", strings.Join(msgs, "\n"))
+	return false
+}
@@ -0,0 +1,70 @@
+package lua
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recorder struct {
+	msgs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestDiff(t *testing.T) {
+	a := Table{
+		Array: []Value{Integer(1), Integer(2), Integer(3)},
+		Hash: map[Value]Value{
+			String("opts"): Table{Hash: map[Value]Value{
+				String("headers"): Table{Hash: map[Value]Value{String("Content-Type"): String("text/plain")}},
+				String("timeout"): Integer(5),
+			}},
+			String("old"): Boolean(true),
+		},
+	}
+	b := Table{
+		Array: []Value{Integer(1), Real(2)},
+		Hash: map[Value]Value{
+			String("opts"): Table{Hash: map[Value]Value{
+				String("headers"): Table{Hash: map[Value]Value{String("Content-Type"): String("text/html")}},
+				String("timeout"): Real(5),
+			}},
+			String("new"): String("x"),
+		},
+	}
+	expected := []string{
+		`[2]: changed 2 -> 2.0`,
+		`[3]: removed 3`,
+		`new: added "x"`,
+		`old: removed true`,
+		`opts.headers["Content-Type"]: changed "text/plain" -> "text/html"`,
+		`opts.timeout: changed 5 -> 5.0`,
+	}
+	diffs := Diff(a, b)
+	if len(diffs) != len(expected) {
+		t.Fatalf("diff count not match: %v", diffs)
+	}
+	for i, d := range diffs {
+		if d.String() != expected[i] {
+			t.Errorf("diff not match:\n%s\n%s", d.String(), expected[i])
+		}
+	}
+
+	diffs = DiffWithOptions(a, b, DiffOptions{NumberEqual: true})
+	if len(diffs) != 4 {
+		t.Errorf("number equal diff not match: %v", diffs)
+	}
+
+	// 数组部分与哈希部分的差异不影响结果
+	hashOnly := Table{Hash: map[Value]Value{Integer(1): String("a"), Real(2): String("b")}}
+	AssertEqual(t, Table{Array: []Value{String("a"), String("b")}}, hashOnly)
+
+	r := &recorder{}
+	if AssertEqual(r, Integer(1), String("1")) || len(r.msgs) != 1 {
+		t.Errorf("assert equal should fail: %v", r.msgs)
+	}
+}
@@ -0,0 +1,62 @@
+package lua
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Hash 返回对象规范序列化结果的 FNV-1a 哈希值, 可用于缓存和去重
+func Hash(v Value) (uint64, error) {
+	data, err := SerializeCanonical([]Value{v})
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64(), nil
+}
+
+// Equal 深度比较两个对象, 结果与比较 SerializeCanonical 的输出一致:
+// Integer 与 Real 不相等, Real 按位比较, 因此 NaN 与自身相等, 内容相同的 String 与 Bytes 相等
+func Equal(a, b Value) bool {
+	if a == nil {
+		a = Nil{}
+	}
+	if b == nil {
+		b = Nil{}
+	}
+	if t, ok := asTable(a); ok {
+		a = t
+	}
+	if t, ok := asTable(b); ok {
+		b = t
+	}
+	if as, ok := stringOf(a); ok {
+		bs, ok := stringOf(b)
+		return ok && as == bs
+	}
+	switch av := a.(type) {
+	case Real:
+		bv, ok := b.(Real)
+		return ok && math.Float64bits(float64(av)) == math.Float64bits(float64(bv))
+	case Table:
+		bv, ok := b.(Table)
+		if !ok || len(av.Array) != len(bv.Array) || len(av.Hash) != len(bv.Hash) {
+			return false
+		}
+		for i := range av.Array {
+			if !Equal(av.Array[i], bv.Array[i]) {
+				return false
+			}
+		}
+		for k, v := range av.Hash {
+			other, ok := bv.Hash[k]
+			if !ok || !Equal(v, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,230 @@
+package lua
+
+import (
+	"cmp"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 将 Lua 对象格式化为 Lua 源码形式的字面量, 用于日志和调试
+// 1. 数组部分按顺序输出, 哈希部分按键排序输出, 保证结果稳定
+// 2. 浮点数总是带有小数点或指数, 与整数区分
+// 3. 轻量用户数据输出为 lightuserdata(0x...), 可由 ParseLiteral 解析
+
+const maxFormatDepth = 100
+
+func Format(v Value) string {
+	return FormatIndent(v, "")
+}
+
+// FormatIndent 在 indent 不为空时每个 Table 字段单独一行, 并按层级缩进
+func FormatIndent(v Value, indent string) string {
+	var sb strings.Builder
+	formatValue(&sb, v, indent, 0)
+	return sb.String()
+}
+
+func (v Table) String() string {
+	return Format(v)
+}
+
+func formatValue(sb *strings.Builder, v Value, indent string, depth int) {
+	if v == nil {
+		v = Nil{}
+	}
+	switch val := v.(type) {
+	case Nil:
+		sb.WriteString("nil")
+	case Boolean:
+		sb.WriteString(strconv.FormatBool(bool(val)))
+	case Integer:
+		sb.WriteString(formatInteger(val))
+	case Real:
+		sb.WriteString(formatReal(val))
+	case String:
+		sb.WriteString(quoteString(string(val)))
+	case Bytes:
+		sb.WriteString(quoteString(string(val)))
+	case LightUserdata:
+		sb.WriteString("lightuserdata(0x" + strconv.FormatUint(uint64(val), 16) + ")")
+	case Table:
+		pairs := make([]Pair, 0, len(val.Hash))
+		for _, k := range sortedKeys(val.Hash) {
+			pairs = append(pairs, Pair{Key: k, Value: val.Hash[k]})
+		}
+		formatTable(sb, val.Array, pairs, indent, depth)
+	case *OrderedTable:
+		formatTable(sb, val.Array, val.Pairs, indent, depth)
+	default:
+		sb.WriteString("nil --[[" + typeName(v) + "]]")
+	}
+}
+
+func formatInteger(v Integer) string {
+	// -9223372036854775808 在 Lua 中会被解析为浮点数
+	if v == math.MinInt64 {
+		return "0x8000000000000000"
+	}
+	return strconv.FormatInt(int64(v), 10)
+}
+
+func formatReal(v Real) string {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f):
+		return "0/0"
+	case math.IsInf(f, 1):
+		return "math.huge"
+	case math.IsInf(f, -1):
+		return "-math.huge"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\v':
+			sb.WriteString(`\v`)
+		default:
+			if c >= utf8.RuneSelf {
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if r != utf8.RuneError || size > 1 {
+					sb.WriteString(s[i : i+size])
+					i += size
+					continue
+				}
+			}
+			if c < 0x20 || c >= 0x7f {
+				// 固定三位, 避免与后续的数字字符连在一起
+				sb.WriteString(`\` + leftPad(strconv.Itoa(int(c)), 3))
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+		i++
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func leftPad(s string, n int) string {
+	for len(s) < n {
+		s = "0" + s
+	}
+	return s
+}
+
+func formatTable(sb *strings.Builder, array []Value, pairs []Pair, indent string, depth int) {
+	if len(array) == 0 && len(pairs) == 0 {
+		sb.WriteString("{}")
+		return
+	}
+	if depth >= maxFormatDepth {
+		sb.WriteString("{...}")
+		return
+	}
+	sb.WriteByte('{')
+	first := true
+	field := func() {
+		if indent != "" {
+			if !first {
+				sb.WriteByte(',')
+			}
+			sb.WriteByte('\n')
+			sb.WriteString(strings.Repeat(indent, depth+1))
+		} else if !first {
+			sb.WriteString(", ")
+		}
+		first = false
+	}
+	for _, v := range array {
+		field()
+		formatValue(sb, v, indent, depth+1)
+	}
+	for _, p := range pairs {
+		field()
+		if s, ok := p.Key.(String); ok && isIdentifier(string(s)) {
+			sb.WriteString(string(s))
+		} else {
+			sb.WriteByte('[')
+			formatValue(sb, p.Key, indent, depth+1)
+			sb.WriteByte(']')
+		}
+		sb.WriteString(" = ")
+		formatValue(sb, p.Value, indent, depth+1)
+	}
+	if indent != "" {
+		sb.WriteString(",\n")
+		sb.WriteString(strings.Repeat(indent, depth))
+	}
+	sb.WriteByte('}')
+}
+
+func sortedKeys(hash map[Value]Value) []Value {
+	keys := make([]Value, 0, len(hash))
+	for k := range hash {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return compareKeys(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// compareKeys 先按 Lua 类型, 再按值比较两个键
+func compareKeys(a, b Value) int {
+	ta, tb := a.LuaType(), b.LuaType()
+	if ta != tb {
+		if ta < tb {
+			return -1
+		}
+		return 1
+	}
+	switch av := a.(type) {
+	case Boolean:
+		bv := b.(Boolean)
+		if av == bv {
+			return 0
+		}
+		if !av {
+			return -1
+		}
+		return 1
+	case Integer:
+		return cmp.Compare(av, b.(Integer))
+	case Real:
+		return cmp.Compare(av, b.(Real))
+	case String:
+		return strings.Compare(string(av), string(b.(String)))
+	case LightUserdata:
+		return cmp.Compare(av, b.(LightUserdata))
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package lua
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	table := Table{
+		Array: []Value{Integer(1), Real(2), String("a\"b\n")},
+		Hash: map[Value]Value{
+			String("name"):   String("x"),
+			String("end"):    Boolean(true),
+			Integer(10):      Boolean(false),
+			Boolean(true):    Table{},
+			String("nested"): Table{Array: []Value{LightUserdata(0xff)}},
+		},
+	}
+	expected := `{1, 2.0, "a\"b\n", [true] = {}, [10] = false, ["end"] = true, name = "x", nested = {lightuserdata(0xff)}}`
+	if s := Format(table); s != expected {
+		t.Errorf("format not match:\n%s\n%s", s, expected)
+	}
+	expected = "{\n\t1,\n\tname = {\n\t\tx = 1,\n\t},\n}"
+	table = Table{
+		Array: []Value{Integer(1)},
+		Hash:  map[Value]Value{String("name"): Table{Hash: map[Value]Value{String("x"): Integer(1)}}},
+	}
+	if s := FormatIndent(table, "\t"); s != expected {
+		t.Errorf("format not match:\n%s\n%s", s, expected)
+	}
+}
+
+func TestParseLiteral(t *testing.T) {
+	values, err := ParseLiteral(`1, -2.5, 0x10, "a\65\x42\z
+		c", [==[long]]string]==], {1, 2, name="x", [10]=true; [2.0]='two', -- comment
+		nested = { [[raw]] }}, nil`)
+	if err != nil {
+		t.Fatalf("parse literal failed: %v", err)
+	}
+	expected := []Value{Integer(1), Real(-2.5), Integer(16), String("aABc"), String("long]]string")}
+	for i, v := range expected {
+		if values[i] != v {
+			t.Errorf("value %d not match: %v != %v", i, values[i], v)
+		}
+	}
+	table := values[5].(Table)
+	if len(table.Array) != 2 || table.Hash[String("name")] != String("x") || table.Hash[Integer(10)] != Boolean(true) {
+		t.Errorf("table not match: %v", table)
+	}
+	if table.Hash[Integer(2)] != String("two") {
+		t.Errorf("real key is not normalized: %v", table)
+	}
+	if table.Hash[String("nested")].(Table).Array[0] != String("raw") {
+		t.Errorf("nested table not match: %v", table)
+	}
+	if values[6] != (Nil{}) {
+		t.Errorf("value not match: %v", values[6])
+	}
+
+	for _, src := range []string{`{1,`, `"abc`, `{a = }`, `foo`, `{[nil] = 1}`} {
+		if _, err := ParseLiteral(src); err == nil {
+			t.Errorf("parse %q should fail", src)
+		}
+	}
+}
+
+func TestFormatParseRoundTrip(t *testing.T) {
+	values := []Value{
+		Integer(math.MinInt64),
+		Integer(math.MaxInt64),
+		Real(math.Inf(-1)),
+		Real(1e300),
+		Real(0.1),
+		String("\x00\x01\xff中文\\"),
+		Table{
+			Array: []Value{Boolean(false), LightUserdata(1)},
+			Hash:  map[Value]Value{String("and"): Real(3), Real(1.5): String("x")},
+		},
+	}
+	for _, v := range values {
+		parsed, err := ParseLiteral(Format(v))
+		if err != nil {
+			t.Errorf("parse %s failed: %v", Format(v), err)
+			continue
+		}
+		if len(parsed) != 1 || !Equal(parsed[0], v) {
+			t.Errorf("value not match: %s != %s", Format(parsed[0]), Format(v))
+		}
+	}
+	nan, err := ParseLiteral(Format(Real(math.NaN())))
+	if err != nil || !math.IsNaN(float64(nan[0].(Real))) {
+		t.Errorf("NaN round trip failed: %v %v", nan, err)
+	}
+}
@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDeserializeTruncated(t *testing.T) {
+	packed, err := Serialize([]Value{
+		Table{
+			Array: []Value{String("hellohellohellohellohellohellohellohello"), Real(1.5)},
+			Hash:  map[Value]Value{String("n"): Integer(0x12345678)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	for i := 1; i < len(packed); i++ {
+		_, err := Deserialize(packed[:i])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("truncated at %d: expected io.ErrUnexpectedEOF, got %v", i, err)
+		}
+	}
+}
+
+func TestDeserializeLimits(t *testing.T) {
+	// table with a declared array size of 2^62 and no data
+	huge := []byte{combineType(TYPE_TABLE, MAX_COOKIE-1), combineType(TYPE_NUMBER, TYPE_NUMBER_QWORD), 0, 0, 0, 0, 0, 0, 0, 0x40}
+	if _, err := Deserialize(huge); err == nil {
+		t.Errorf("huge array size should fail")
+	}
+
+	packed, _ := Serialize([]Value{String("hellohellohellohellohellohellohellohello")})
+	_, err := DeserializeWithOptions(packed, DecodeOptions{MaxStringLength: 10})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+	_, err = DeserializeWithOptions(packed, DecodeOptions{MaxTotalSize: 8})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	nested := Value(Table{Array: []Value{Integer(1)}})
+	for i := 0; i < 4; i++ {
+		nested = Table{Array: []Value{nested}}
+	}
+	packed, _ = Serialize([]Value{nested})
+	_, err = DeserializeWithOptions(packed, DecodeOptions{MaxDepth: 3})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+	if _, err := DeserializeWithOptions(packed, DecodeOptions{MaxDepth: 5}); err != nil {
+		t.Errorf("deserialize failed: %v", err)
+	}
+}
+
+func FuzzDeserialize(f *testing.F) {
+	seeds := [][]Value{
+		{Nil{}, Boolean(true), Integer(-1), Integer(0x7FFFFFFFFF), Real(3.14)},
+		{String("hello"), String("hellohellohellohellohellohellohellohello")},
+		{Table{
+			Array: []Value{Integer(1), String("a"), Table{Hash: map[Value]Value{String("k"): Boolean(false)}}},
+			Hash:  map[Value]Value{String("x"): Real(1.5), Integer(100): String("y")},
+		}},
+	}
+	for _, seed := range seeds {
+		packed, err := Serialize(seed)
+		if err != nil {
+			f.Fatalf("serialize seed failed: %v", err)
+		}
+		f.Add(packed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		values, err := Deserialize(data)
+		if err != nil {
+			return
+		}
+		packed, err := Serialize(values)
+		if err != nil {
+			return
+		}
+		again, err := Deserialize(packed)
+		if err != nil {
+			t.Fatalf("deserialize serialized values failed: %v", err)
+		}
+		if len(again) != len(values) {
+			t.Fatalf("value count not match: %d != %d", len(again), len(values))
+		}
+		for i := range values {
+			if !Equal(values[i], again[i]) {
+				t.Fatalf("value not match: %v != %v", values[i], again[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package lua
+
+import "fmt"
+
+// 泛型的转换函数, 规则与 Marshal/Unmarshal 相同:
+//
+//	cluster.Call("db", "agent", "get", lua.Args("user", 1001, time.Now()))
+//	name, err := lua.As[string](ret[0])
+
+// From 将 Go 对象转换为 Lua 对象, 不支持的类型会 panic, 只用于类型确定的字面量.
+// 转换运行时的数据使用 TryFrom
+func From(v any) Value {
+	lv, err := TryFrom(v)
+	if err != nil {
+		panic(err)
+	}
+	return lv
+}
+
+// TryFrom 与 From 相同, 不支持的类型返回错误
+func TryFrom(v any) (Value, error) {
+	return Marshal(v)
+}
+
+// As 将 Lua 对象转换为 T
+func As[T any](v Value) (T, error) {
+	var out T
+	err := Unmarshal(v, &out)
+	return out, err
+}
+
+// Args 将参数列表转换为 []Value, 用于 Call/Send 和服务的返回值, 不支持的类型会 panic
+func Args(args ...any) []Value {
+	values, err := TryArgs(args...)
+	if err != nil {
+		panic(err)
+	}
+	return values
+}
+
+// TryArgs 与 Args 相同, 不支持的类型返回错误
+func TryArgs(args ...any) ([]Value, error) {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		v, err := TryFrom(arg)
+		if err != nil {
+			return nil, fmt.Errorf("arg %d: %w", i+1, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,63 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromAs(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	args := Args("get", 42, 1.5, true, []byte{0, 1}, []string{"a", "b"}, map[int]string{1: "x", 10: "y"}, now, nil)
+	expected := []Value{
+		String("get"), Integer(42), Real(1.5), Boolean(true), String("\x00\x01"),
+		Table{Array: []Value{String("a"), String("b")}},
+		Table{Hash: map[Value]Value{Integer(1): String("x"), Integer(10): String("y")}},
+		Integer(1700000000), Nil{},
+	}
+	for i := range expected {
+		if !Equal(args[i], expected[i]) {
+			t.Errorf("args[%d] not match: %v != %v", i, args[i], expected[i])
+		}
+	}
+
+	if s, err := As[string](args[0]); err != nil || s != "get" {
+		t.Errorf("as string failed: %v %v", s, err)
+	}
+	if b, err := As[[]byte](args[4]); err != nil || string(b) != "\x00\x01" {
+		t.Errorf("as bytes failed: %v %v", b, err)
+	}
+	if m, err := As[map[int]string](args[6]); err != nil || m[10] != "y" {
+		t.Errorf("as map failed: %v %v", m, err)
+	}
+	if tm, err := As[time.Time](args[7]); err != nil || !tm.Equal(now) {
+		t.Errorf("as time failed: %v %v", tm, err)
+	}
+	if tm, err := As[time.Time](From(now.Add(500 * time.Millisecond))); err != nil || !tm.Equal(now.Add(500*time.Millisecond)) {
+		t.Errorf("as fractional time failed: %v %v", tm, err)
+	}
+	if _, err := As[int](args[0]); err == nil {
+		t.Errorf("expected type mismatch")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for unsupported type")
+		}
+	}()
+	From(make(chan int))
+}
+
+func TestTryArgs(t *testing.T) {
+	if _, err := TryFrom(make(chan int)); err == nil {
+		t.Errorf("expected unsupported type error")
+	}
+	if _, err := TryArgs("get", make(chan int)); err == nil || !strings.Contains(err.Error(), "arg 2") {
+		t.Errorf("expected arg 2 error, got %v", err)
+	}
+	args, err := TryArgs("get", 1)
+	if err != nil {
+		t.Fatalf("try args failed: %v", err)
+	}
+	AssertEqual(t, Table{Array: Args("get", 1)}, Table{Array: args})
+}
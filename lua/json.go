@@ -0,0 +1,352 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Lua 对象与 JSON 之间的转换, 与 lua-cjson 的约定保持一致
+// 1. 键全部为正整数的 Table 编码为数组, 空洞以 null 填充; 过于稀疏时按 SparseConvert 处理
+// 2. 空 Table 默认编码为对象, EmptyTableAsArray 时编码为数组
+// 3. JSON null 对应 Null (与 cjson.null 相同, 值为 0 的轻量用户数据)
+// 4. 整数与浮点数保持区分, 整数值的浮点数编码为 1.0 的形式
+
+var Null = LightUserdata(0)
+
+type JSONOptions struct {
+	EmptyTableAsArray bool
+	SparseConvert     bool // 过于稀疏的数组编码为对象, 否则返回错误
+	SparseRatio       int  // 默认 2, 小于 0 时不检查稀疏
+	SparseSafe        int  // 默认 10, 不超过该长度的数组不检查稀疏
+	NumberPrecision   int  // 浮点数的有效位数, 默认为无损的最短表示
+	MaxDepth          int  // 默认 1000
+}
+
+func (o JSONOptions) withDefaults() JSONOptions {
+	if o.SparseRatio == 0 {
+		o.SparseRatio = 2
+	}
+	if o.SparseSafe <= 0 {
+		o.SparseSafe = 10
+	}
+	if o.NumberPrecision <= 0 {
+		o.NumberPrecision = -1
+	}
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = 1000
+	}
+	return o
+}
+
+func ToJSON(v Value, opts JSONOptions) ([]byte, error) {
+	enc := jsonEncoder{opts: opts.withDefaults()}
+	if err := enc.encode(v, 0); err != nil {
+		return nil, err
+	}
+	return enc.buf.Bytes(), nil
+}
+
+type jsonEncoder struct {
+	buf  bytes.Buffer
+	opts JSONOptions
+}
+
+func (e *jsonEncoder) encode(v Value, depth int) error {
+	if v == nil {
+		v = Nil{}
+	}
+	switch val := v.(type) {
+	case Nil:
+		e.buf.WriteString("null")
+	case Boolean:
+		e.buf.WriteString(strconv.FormatBool(bool(val)))
+	case Integer:
+		e.buf.WriteString(strconv.FormatInt(int64(val), 10))
+	case Real:
+		s, err := e.formatReal(val)
+		if err != nil {
+			return err
+		}
+		e.buf.WriteString(s)
+	case String:
+		writeJSONString(&e.buf, string(val))
+	case Bytes:
+		writeJSONString(&e.buf, string(val))
+	case LightUserdata:
+		if val != Null {
+			return fmt.Errorf("cannot serialise userdata: type not supported")
+		}
+		e.buf.WriteString("null")
+	case Table, *OrderedTable:
+		if ot, ok := val.(*OrderedTable); ok && ot.Err() != nil {
+			return ot.Err()
+		}
+		if depth >= e.opts.MaxDepth {
+			return fmt.Errorf("cannot serialise, excessive nesting (%d)", depth+1)
+		}
+		t, _ := asTable(val)
+		return e.encodeTable(t, depth+1)
+	default:
+		return fmt.Errorf("cannot serialise %s: type not supported", typeName(v))
+	}
+	return nil
+}
+
+func (e *jsonEncoder) formatReal(v Real) (string, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("cannot serialise number: must not be NaN or Infinity")
+	}
+	s := strconv.FormatFloat(f, 'g', e.opts.NumberPrecision, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s, nil
+}
+
+// arrayLength 返回 Table 作为 JSON 数组时的长度, 不是数组时返回 -1
+func (e *jsonEncoder) arrayLength(t Table) (int, error) {
+	maxIndex := len(t.Array)
+	count := len(t.Array)
+	for k := range t.Hash {
+		idx, ok := arrayIndex(k)
+		if !ok {
+			return -1, nil
+		}
+		if idx > maxIndex {
+			maxIndex = idx
+		}
+		count++
+	}
+	if e.opts.SparseRatio > 0 && maxIndex > e.opts.SparseSafe && maxIndex > count*e.opts.SparseRatio {
+		if !e.opts.SparseConvert {
+			return 0, fmt.Errorf("cannot serialise table: excessively sparse array")
+		}
+		return -1, nil
+	}
+	return maxIndex, nil
+}
+
+func arrayIndex(k Value) (int, bool) {
+	switch n := k.(type) {
+	case Integer:
+		if n >= 1 && int64(n) <= math.MaxInt32 {
+			return int(n), true
+		}
+	case Real:
+		f := float64(n)
+		if f >= 1 && f <= math.MaxInt32 && math.Floor(f) == f {
+			return int(f), true
+		}
+	}
+	return 0, false
+}
+
+func (e *jsonEncoder) encodeTable(t Table, depth int) error {
+	if len(t.Array) == 0 && len(t.Hash) == 0 {
+		if e.opts.EmptyTableAsArray {
+			e.buf.WriteString("[]")
+		} else {
+			e.buf.WriteString("{}")
+		}
+		return nil
+	}
+	n, err := e.arrayLength(t)
+	if err != nil {
+		return err
+	}
+	if n >= 0 {
+		return e.encodeArray(t, n, depth)
+	}
+	return e.encodeObject(t, depth)
+}
+
+func (e *jsonEncoder) encodeArray(t Table, n int, depth int) error {
+	items := make([]Value, n)
+	copy(items, t.Array)
+	for k, v := range t.Hash {
+		idx, _ := arrayIndex(k)
+		items[idx-1] = v
+	}
+	e.buf.WriteByte('[')
+	for i, v := range items {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		if err := e.encode(v, depth); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+	return nil
+}
+
+func (e *jsonEncoder) encodeObject(t Table, depth int) error {
+	type member struct {
+		key   string
+		value Value
+	}
+	members := make([]member, 0, len(t.Array)+len(t.Hash))
+	for i, v := range t.Array {
+		members = append(members, member{strconv.Itoa(i + 1), v})
+	}
+	for k, v := range t.Hash {
+		var key string
+		switch kv := k.(type) {
+		case String:
+			key = string(kv)
+		case Integer:
+			key = strconv.FormatInt(int64(kv), 10)
+		case Real:
+			s, err := e.formatReal(kv)
+			if err != nil {
+				return err
+			}
+			key = s
+		default:
+			return fmt.Errorf("cannot serialise %s: table key must be a number or string", typeName(k))
+		}
+		members = append(members, member{key, v})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].key < members[j].key
+	})
+	e.buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		writeJSONString(&e.buf, m.key)
+		e.buf.WriteByte(':')
+		if err := e.encode(m.value, depth); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// 与 cjson 相同, 非 UTF-8 的字节原样输出
+func writeJSONString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func FromJSON(data []byte, opts JSONOptions) (Value, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("json data is not valid utf-8")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeJSON(dec, 0, opts.withDefaults().MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("json data has trailing content")
+	}
+	return v, nil
+}
+
+func decodeJSON(dec *json.Decoder, depth int, maxDepth int) (Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case nil:
+		return Null, nil
+	case bool:
+		return Boolean(t), nil
+	case json.Number:
+		return parseJSONNumber(t)
+	case string:
+		return String(t), nil
+	case json.Delim:
+		if depth >= maxDepth {
+			return nil, fmt.Errorf("found too many nested data structures (%d)", depth+1)
+		}
+		switch t {
+		case '[':
+			array := make([]Value, 0)
+			for dec.More() {
+				v, err := decodeJSON(dec, depth+1, maxDepth)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return Table{Array: array}, nil
+		case '{':
+			hash := make(map[Value]Value)
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeJSON(dec, depth+1, maxDepth)
+				if err != nil {
+					return nil, err
+				}
+				hash[String(key.(string))] = v
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return Table{Hash: hash}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected json token: %v", tok)
+}
+
+func parseJSONNumber(n json.Number) (Value, error) {
+	s := string(n)
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return Integer(i), nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json number: %s", s)
+	}
+	return Real(f), nil
+}
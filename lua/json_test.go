@@ -0,0 +1,71 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	cases := []struct {
+		value    Value
+		opts     JSONOptions
+		expected string
+	}{
+		{Table{}, JSONOptions{}, `{}`},
+		{Table{}, JSONOptions{EmptyTableAsArray: true}, `[]`},
+		{Table{Array: []Value{Integer(1), Real(2), String("a\n")}}, JSONOptions{}, `[1,2.0,"a\n"]`},
+		{Table{
+			Array: []Value{Integer(1)},
+			Hash:  map[Value]Value{Integer(2): Boolean(true), Integer(4): Null},
+		}, JSONOptions{}, `[1,true,null,null]`},
+		{Table{Hash: map[Value]Value{String("b"): Integer(1), Integer(1): String("x")}}, JSONOptions{}, `{"1":"x","b":1}`},
+		{Table{Hash: map[Value]Value{Integer(1): Integer(1), Integer(20): Integer(20)}}, JSONOptions{SparseConvert: true}, `{"1":1,"20":20}`},
+	}
+	for _, c := range cases {
+		data, err := ToJSON(c.value, c.opts)
+		if err != nil {
+			t.Errorf("to json failed: %v", err)
+			continue
+		}
+		if string(data) != c.expected {
+			t.Errorf("json not match: %s != %s", data, c.expected)
+		}
+	}
+
+	sparse := Table{Hash: map[Value]Value{Integer(1): Integer(1), Integer(20): Integer(20)}}
+	if _, err := ToJSON(sparse, JSONOptions{}); err == nil {
+		t.Errorf("excessively sparse array should fail")
+	}
+	if _, err := ToJSON(Table{Hash: map[Value]Value{Boolean(true): Integer(1)}}, JSONOptions{}); err == nil {
+		t.Errorf("boolean key should fail")
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	v, err := FromJSON([]byte(`{"a":[1,2.0,null,"x"],"b":{"c":false},"d":1e3}`), JSONOptions{})
+	if err != nil {
+		t.Fatalf("from json failed: %v", err)
+	}
+	hash := v.(Table).Hash
+	array := hash[String("a")].(Table).Array
+	if array[0] != Integer(1) || array[1] != Real(2) || array[2] != Null || array[3] != String("x") {
+		t.Errorf("array not match: %v", array)
+	}
+	if hash[String("b")].(Table).Hash[String("c")] != Boolean(false) {
+		t.Errorf("object not match: %v", hash[String("b")])
+	}
+	if hash[String("d")] != Real(1000) {
+		t.Errorf("number not match: %v", hash[String("d")])
+	}
+	if _, err := FromJSON([]byte(`[1] [2]`), JSONOptions{}); err == nil {
+		t.Errorf("trailing content should fail")
+	}
+
+	data, err := ToJSON(v, JSONOptions{})
+	if err != nil {
+		t.Fatalf("to json failed: %v", err)
+	}
+	expected := `{"a":[1,2.0,null,"x"],"b":{"c":false},"d":1000.0}`
+	if string(data) != expected {
+		t.Errorf("json not match: %s != %s", data, expected)
+	}
+}
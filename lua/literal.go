@@ -0,0 +1,480 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 解析 Lua 常量表达式, 支持的语法:
+// 1. nil, true, false, 数字 (包括十六进制与浮点数), 字符串 (包括长字符串)
+// 2. Table 构造器 {1, 2, name = "x", [10] = true}
+// 3. Format 输出的 math.huge, 0/0 与 lightuserdata(0x...)
+// 4. 多个表达式以逗号分隔, 与 return 语句相同
+
+func ParseLiteral(src string) ([]Value, error) {
+	p := &literalParser{src: src}
+	values := make([]Value, 0)
+	p.skipSpace()
+	if p.eof() {
+		return values, nil
+	}
+	for {
+		v, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		p.skipSpace()
+		if p.eof() {
+			return values, nil
+		}
+		if !p.accept(",") {
+			return nil, p.errorf("unexpected %q", p.peekToken())
+		}
+	}
+}
+
+// ParseAssignments 解析由 name = 常量表达式 组成的代码块, 例如 skynet 的配置文件, 返回以名字为键的 Table.
+// 与执行代码块相同, 后面的赋值覆盖前面的, 赋值为 nil 的名字不在结果中
+func ParseAssignments(src string) (Table, error) {
+	p := &literalParser{src: src}
+	hash := make(map[Value]Value)
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return Table{Hash: hash}, nil
+		}
+		if p.accept(";") {
+			continue
+		}
+		c := p.peek()
+		if !isNameChar(c) || (c >= '0' && c <= '9') {
+			return Table{}, p.errorf("unexpected %q, expected name", p.peekToken())
+		}
+		name := p.readName()
+		if !p.accept("=") {
+			return Table{}, p.errorf("'=' expected near %q", p.peekToken())
+		}
+		v, err := p.parseExpr(0)
+		if err != nil {
+			return Table{}, err
+		}
+		if v.LuaType() == LUA_NIL {
+			delete(hash, String(name))
+		} else {
+			hash[String(name)] = v
+		}
+	}
+}
+
+type literalParser struct {
+	src string
+	pos int
+}
+
+func (p *literalParser) errorf(format string, args ...any) error {
+	line := 1 + strings.Count(p.src[:p.pos], "\n")
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *literalParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *literalParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *literalParser) peekToken() string {
+	if p.eof() {
+		return "<eof>"
+	}
+	end := p.pos + 1
+	for end < len(p.src) && end-p.pos < 10 && isNameChar(p.src[end]) && isNameChar(p.src[p.pos]) {
+		end++
+	}
+	return p.src[p.pos:end]
+}
+
+func (p *literalParser) accept(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *literalParser) skipSpace() {
+	for !p.eof() {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v' {
+			p.pos++
+			continue
+		}
+		if strings.HasPrefix(p.src[p.pos:], "--") {
+			p.pos += 2
+			if level := p.longBracketLevel(); level >= 0 {
+				p.readLongString(level)
+				continue
+			}
+			for !p.eof() && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *literalParser) readName() string {
+	start := p.pos
+	for !p.eof() && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *literalParser) parseExpr(depth int) (Value, error) {
+	if depth > maxFormatDepth {
+		return nil, p.errorf("literal is too deep")
+	}
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("unexpected <eof>")
+	}
+	c := p.peek()
+	switch {
+	case c == '{':
+		p.pos++
+		return p.parseTable(depth + 1)
+	case c == '"' || c == '\'':
+		s, err := p.readString(c)
+		return String(s), err
+	case c == '[':
+		level := p.longBracketLevel()
+		if level < 0 {
+			return nil, p.errorf("unexpected %q", p.peekToken())
+		}
+		s, err := p.readLongString(level)
+		return String(s), err
+	case c == '-':
+		p.pos++
+		v, err := p.parseExpr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch n := v.(type) {
+		case Integer:
+			return -n, nil
+		case Real:
+			return -n, nil
+		}
+		return nil, p.errorf("attempt to negate a %s value", typeName(v))
+	case c == '.' || (c >= '0' && c <= '9'):
+		n, err := p.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept("/") {
+			p.skipSpace()
+			d, err := p.readNumber()
+			if err != nil {
+				return nil, err
+			}
+			return Real(toFloat(n) / toFloat(d)), nil
+		}
+		return n, nil
+	case isNameChar(c):
+		name := p.readName()
+		switch name {
+		case "nil":
+			return Nil{}, nil
+		case "true":
+			return Boolean(true), nil
+		case "false":
+			return Boolean(false), nil
+		case "math":
+			if p.accept(".") && p.readName() == "huge" {
+				return Real(math.Inf(1)), nil
+			}
+		case "lightuserdata":
+			if p.accept("(") {
+				p.skipSpace()
+				n, err := p.readNumber()
+				if err != nil {
+					return nil, err
+				}
+				i, ok := n.(Integer)
+				if !ok || !p.accept(")") {
+					return nil, p.errorf("invalid lightuserdata")
+				}
+				return LightUserdata(i), nil
+			}
+		}
+		return nil, p.errorf("unexpected name %q, only constants are supported", name)
+	}
+	return nil, p.errorf("unexpected %q", p.peekToken())
+}
+
+func toFloat(v Value) float64 {
+	if i, ok := v.(Integer); ok {
+		return float64(i)
+	}
+	return float64(v.(Real))
+}
+
+func (p *literalParser) parseTable(depth int) (Value, error) {
+	table := Table{
+		Array: make([]Value, 0),
+		Hash:  make(map[Value]Value),
+	}
+	for {
+		if p.accept("}") {
+			return table, nil
+		}
+		p.skipSpace()
+		start := p.pos
+		var key Value
+		if p.peek() == '[' && p.longBracketLevel() < 0 {
+			p.pos++
+			k, err := p.parseExpr(depth)
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept("]") || !p.accept("=") {
+				return nil, p.errorf("'=' expected near %q", p.peekToken())
+			}
+			key = k
+		} else if isNameChar(p.peek()) && !(p.peek() >= '0' && p.peek() <= '9') {
+			name := p.readName()
+			if p.accept("=") && !strings.HasPrefix(p.src[p.pos:], "=") {
+				key = String(name)
+			} else {
+				p.pos = start
+			}
+		}
+		v, err := p.parseExpr(depth)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			table.Array = append(table.Array, v)
+		} else {
+			key = normalizeKey(key)
+			if err := checkTableKey(key); err != nil || key.LuaType() == LUA_NIL {
+				return nil, p.errorf("invalid table key: %s", Format(key))
+			}
+			if v.LuaType() == LUA_NIL {
+				delete(table.Hash, key)
+			} else {
+				table.Hash[key] = v
+			}
+		}
+		if !p.accept(",") && !p.accept(";") {
+			if !p.accept("}") {
+				return nil, p.errorf("'}' expected near %q", p.peekToken())
+			}
+			return table, nil
+		}
+	}
+}
+
+// 与 Lua 5.3 相同, 值为整数的浮点数键转换为整数键
+func normalizeKey(k Value) Value {
+	if b, ok := k.(Bytes); ok {
+		return String(b)
+	}
+	if r, ok := k.(Real); ok {
+		if i, ok := toInteger(r); ok {
+			return Integer(i)
+		}
+	}
+	return k
+}
+
+func (p *literalParser) readNumber() (Value, error) {
+	start := p.pos
+	src := p.src
+	isHex := strings.HasPrefix(src[p.pos:], "0x") || strings.HasPrefix(src[p.pos:], "0X")
+	if isHex {
+		p.pos += 2
+	}
+	for !p.eof() {
+		c := src[p.pos]
+		if isNameChar(c) || c == '.' {
+			p.pos++
+		} else if (c == '+' || c == '-') && p.pos > start {
+			prev := src[p.pos-1] | 0x20
+			if (isHex && prev == 'p') || (!isHex && prev == 'e') {
+				p.pos++
+			} else {
+				break
+			}
+		} else {
+			break
+		}
+	}
+	text := src[start:p.pos]
+	if isHex && !strings.ContainsAny(text[2:], ".pP") {
+		// 与 Lua 5.3 相同, 十六进制整数溢出时回绕
+		var n uint64
+		if len(text) == 2 {
+			return nil, p.errorf("malformed number near %q", text)
+		}
+		for _, c := range text[2:] {
+			d, err := strconv.ParseUint(string(c), 16, 8)
+			if err != nil {
+				return nil, p.errorf("malformed number near %q", text)
+			}
+			n = n<<4 | d
+		}
+		return Integer(n), nil
+	}
+	if !isHex && !strings.ContainsAny(text, ".eE") {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return Integer(i), nil
+		}
+	}
+	if isHex && !strings.ContainsAny(text, "pP") {
+		text += "p0"
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil && !strings.Contains(err.Error(), "out of range") {
+		return nil, p.errorf("malformed number near %q", text)
+	}
+	return Real(f), nil
+}
+
+func (p *literalParser) readString(quote byte) (string, error) {
+	p.pos++
+	var sb strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unfinished string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return sb.String(), nil
+		case '\n', '\r':
+			return "", p.errorf("unfinished string")
+		case '\\':
+			if err := p.readEscape(&sb); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+func (p *literalParser) readEscape(sb *strings.Builder) error {
+	if p.eof() {
+		return p.errorf("unfinished string")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n', '\n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\\', '"', '\'':
+		sb.WriteByte(c)
+	case 'x':
+		if p.pos+2 > len(p.src) {
+			return p.errorf("hexadecimal digit expected")
+		}
+		n, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+		if err != nil {
+			return p.errorf("hexadecimal digit expected")
+		}
+		p.pos += 2
+		sb.WriteByte(byte(n))
+	case 'z':
+		for !p.eof() && strings.IndexByte(" \t\n\r\f\v", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+	case 'u':
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if !strings.HasPrefix(p.src[p.pos:], "{") || end < 0 {
+			return p.errorf("missing '{' or '}' in \\u{xxxx}")
+		}
+		n, err := strconv.ParseUint(p.src[p.pos+1:p.pos+end], 16, 32)
+		if err != nil {
+			return p.errorf("UTF-8 value too large")
+		}
+		p.pos += end + 1
+		sb.WriteString(string(utf8.AppendRune(nil, rune(n))))
+	default:
+		if c < '0' || c > '9' {
+			return p.errorf("invalid escape sequence '\\%c'", c)
+		}
+		n := int(c - '0')
+		for i := 0; i < 2 && !p.eof() && p.src[p.pos] >= '0' && p.src[p.pos] <= '9'; i++ {
+			n = n*10 + int(p.src[p.pos]-'0')
+			p.pos++
+		}
+		if n > 255 {
+			return p.errorf("decimal escape too large")
+		}
+		sb.WriteByte(byte(n))
+	}
+	return nil
+}
+
+// longBracketLevel 返回 [==[ 中等号的个数, 不是长括号时返回 -1
+func (p *literalParser) longBracketLevel() int {
+	if p.peek() != '[' {
+		return -1
+	}
+	i := p.pos + 1
+	for i < len(p.src) && p.src[i] == '=' {
+		i++
+	}
+	if i < len(p.src) && p.src[i] == '[' {
+		return i - p.pos - 1
+	}
+	return -1
+}
+
+func (p *literalParser) readLongString(level int) (string, error) {
+	p.pos += level + 2
+	// 跳过紧接着开始括号的换行
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+	} else if p.peek() == '\n' || p.peek() == '\r' {
+		p.pos++
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(p.src[p.pos:], closing)
+	if end < 0 {
+		p.pos = len(p.src)
+		return "", p.errorf("unfinished long string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + len(closing)
+	return s, nil
+}
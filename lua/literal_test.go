@@ -0,0 +1,30 @@
+package lua
+
+import "testing"
+
+func TestParseAssignments(t *testing.T) {
+	got, err := ParseAssignments(`
+-- skynet cluster config
+db = "127.0.0.1:2528"
+db2 = "127.0.0.1:2529"; game = false
+__nowaiting = true
+db2 = nil
+opts = { retry = 3 }
+`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	want := Table{Hash: map[Value]Value{
+		String("db"):          String("127.0.0.1:2528"),
+		String("game"):        Boolean(false),
+		String("__nowaiting"): Boolean(true),
+		String("opts"):        Table{Hash: map[Value]Value{String("retry"): Integer(3)}},
+	}}
+	AssertEqual(t, want, got)
+
+	for _, src := range []string{`db`, `db = `, `1 = 2`, `db = "a" ,`} {
+		if _, err := ParseAssignments(src); err == nil {
+			t.Errorf("parse %q should fail", src)
+		}
+	}
+}
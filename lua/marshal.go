@@ -0,0 +1,575 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Go 对象与 Lua 对象之间的反射转换
+// 1. 结构体字段名来自 `lua:"name,omitempty"` 标签，`lua:"-"` 忽略该字段
+// 2. Slice/Array 对应 Table.Array，Map/Struct 对应 Table.Hash
+// 3. 目标类型本身是 Value 时直接赋值，不做转换
+// 4. time.Time 对应 Unix 时间戳 (秒)，不足一秒时为 Real；[]byte 对应 Bytes
+
+type PathError struct {
+	Path string
+	Msg  string
+}
+
+func (e *PathError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+func pathErrorf(path string, format string, args ...any) error {
+	return &PathError{Path: path, Msg: fmt.Sprintf(format, args...)}
+}
+
+func typeName(v Value) string {
+	if v == nil {
+		return "nil"
+	}
+	switch v.LuaType() {
+	case LUA_NIL:
+		return "nil"
+	case LUA_BOOLEAN:
+		return "boolean"
+	case LUA_INTEGER:
+		return "integer"
+	case LUA_REAL:
+		return "real"
+	case LUA_STRING:
+		return "string"
+	case LUA_TABLE:
+		return "table"
+	case LUA_LIGHTUSERDATA:
+		return "userdata"
+	default:
+		return fmt.Sprintf("type(%d)", v.LuaType())
+	}
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || luaKeywords[s] {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			continue
+		}
+		if i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+func joinField(path string, name string) string {
+	if !isIdentifier(name) {
+		return path + "[" + strconv.Quote(name) + "]"
+	}
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func joinKey(path string, key Value) string {
+	switch k := key.(type) {
+	case String:
+		return path + "[" + strconv.Quote(string(k)) + "]"
+	case Integer:
+		return path + "[" + strconv.FormatInt(int64(k), 10) + "]"
+	case Real:
+		return path + "[" + strconv.FormatFloat(float64(k), 'g', -1, 64) + "]"
+	case Boolean:
+		return path + "[" + strconv.FormatBool(bool(k)) + "]"
+	case LightUserdata:
+		return path + fmt.Sprintf("[userdata: 0x%x]", uint64(k))
+	default:
+		return path + "[?]"
+	}
+}
+
+type fieldInfo struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]fieldInfo
+
+func cachedFields(t reflect.Type) []fieldInfo {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]fieldInfo)
+	}
+	fields := typeFields(t, nil)
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.([]fieldInfo)
+}
+
+func typeFields(t reflect.Type, index []int) []fieldInfo {
+	fields := make([]fieldInfo, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("lua")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int(nil), index...), i)
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, typeFields(sf.Type, fieldIndex)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, fieldInfo{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+var (
+	valueType = reflect.TypeOf((*Value)(nil)).Elem()
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+func Marshal(v any) (Value, error) {
+	if v == nil {
+		return Nil{}, nil
+	}
+	m := marshaler{}
+	return m.marshalValue(reflect.ValueOf(v), "")
+}
+
+// marshaler 记录 Table 的嵌套深度以及当前路径上的指针, 循环引用返回错误而不是栈溢出
+type marshaler struct {
+	depth    int
+	pointers map[pointerKey]struct{}
+}
+
+type pointerKey struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// enter 进入一层 Table, 与序列化相同, 最多 DEFAULT_MAX_DEPTH 层
+func (m *marshaler) enter(path string) error {
+	if m.depth >= DEFAULT_MAX_DEPTH {
+		return pathErrorf(path, "excessive nesting (%d), possibly a cycle", m.depth+1)
+	}
+	m.depth++
+	return nil
+}
+
+func (m *marshaler) leave() {
+	m.depth--
+}
+
+func (m *marshaler) marshalValue(rv reflect.Value, path string) (Value, error) {
+	if !rv.IsValid() {
+		return Nil{}, nil
+	}
+	if rv.Type().Implements(valueType) {
+		// 包括值为 nil 的 *OrderedTable
+		if (rv.Kind() == reflect.Interface || rv.Kind() == reflect.Pointer) && rv.IsNil() {
+			return Nil{}, nil
+		}
+		return rv.Interface().(Value), nil
+	}
+	if rv.Type() == timeType {
+		return marshalTime(rv.Interface().(time.Time)), nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return Boolean(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Integer(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, pathErrorf(path, "integer overflow: %d", u)
+		}
+		return Integer(u), nil
+	case reflect.Float32, reflect.Float64:
+		return Real(rv.Float()), nil
+	case reflect.String:
+		return String(rv.String()), nil
+	case reflect.Interface:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		return m.marshalValue(rv.Elem(), path)
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		key := pointerKey{rv.Pointer(), rv.Type()}
+		if _, ok := m.pointers[key]; ok {
+			return nil, pathErrorf(path, "encountered a cycle via %v", rv.Type())
+		}
+		if m.pointers == nil {
+			m.pointers = make(map[pointerKey]struct{})
+		}
+		m.pointers[key] = struct{}{}
+		defer delete(m.pointers, key)
+		return m.marshalValue(rv.Elem(), path)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// 与 rv 共享内存, 不做复制
+			return Bytes(rv.Bytes()), nil
+		}
+		return m.marshalArray(rv, path)
+	case reflect.Array:
+		return m.marshalArray(rv, path)
+	case reflect.Map:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		return m.marshalMap(rv, path)
+	case reflect.Struct:
+		return m.marshalStruct(rv, path)
+	default:
+		return nil, pathErrorf(path, "unsupported go type: %v", rv.Type())
+	}
+}
+
+func marshalTime(t time.Time) Value {
+	if t.Nanosecond() == 0 {
+		return Integer(t.Unix())
+	}
+	return Real(float64(t.UnixNano()) / float64(time.Second))
+}
+
+func unmarshalTime(v Value, rv reflect.Value, path string) error {
+	switch n := v.(type) {
+	case Integer:
+		rv.Set(reflect.ValueOf(time.Unix(int64(n), 0)))
+	case Real:
+		sec, frac := math.Modf(float64(n))
+		rv.Set(reflect.ValueOf(time.Unix(int64(sec), int64(math.Round(frac*float64(time.Second))))))
+	default:
+		return mismatch(path, "number", v)
+	}
+	return nil
+}
+
+func (m *marshaler) marshalArray(rv reflect.Value, path string) (Value, error) {
+	if err := m.enter(path); err != nil {
+		return nil, err
+	}
+	defer m.leave()
+	array := make([]Value, rv.Len())
+	for i := range array {
+		itemPath := joinKey(path, Integer(i+1))
+		v, err := m.marshalValue(rv.Index(i), itemPath)
+		if err != nil {
+			return nil, err
+		}
+		if v.LuaType() == LUA_NIL {
+			return nil, pathErrorf(itemPath, "array value can't be nil")
+		}
+		array[i] = v
+	}
+	return Table{Array: array}, nil
+}
+
+func (m *marshaler) marshalMap(rv reflect.Value, path string) (Value, error) {
+	if err := m.enter(path); err != nil {
+		return nil, err
+	}
+	defer m.leave()
+	hash := make(map[Value]Value, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k, err := m.marshalValue(iter.Key(), path)
+		if err != nil {
+			return nil, err
+		}
+		if k.LuaType() == LUA_NIL || k.LuaType() == LUA_TABLE {
+			return nil, pathErrorf(path, "table key can't be %s", typeName(k))
+		}
+		v, err := m.marshalValue(iter.Value(), joinKey(path, k))
+		if err != nil {
+			return nil, err
+		}
+		if v.LuaType() == LUA_NIL {
+			continue
+		}
+		hash[k] = v
+	}
+	return Table{Hash: hash}, nil
+}
+
+func (m *marshaler) marshalStruct(rv reflect.Value, path string) (Value, error) {
+	if err := m.enter(path); err != nil {
+		return nil, err
+	}
+	defer m.leave()
+	fields := cachedFields(rv.Type())
+	hash := make(map[Value]Value, len(fields)+1)
+	if tag, ok := typeTag(rv.Type()); ok {
+		hash[String(TYPE_FIELD)] = String(tag)
+	}
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		v, err := m.marshalValue(fv, joinField(path, f.name))
+		if err != nil {
+			return nil, err
+		}
+		if v.LuaType() == LUA_NIL {
+			continue
+		}
+		hash[String(f.name)] = v
+	}
+	return Table{Hash: hash}, nil
+}
+
+func Unmarshal(v Value, out any) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("unmarshal target must be a non-nil pointer, got %T", out)
+	}
+	if v == nil {
+		v = Nil{}
+	}
+	return unmarshalValue(v, rv.Elem(), "")
+}
+
+func unmarshalValue(v Value, rv reflect.Value, path string) error {
+	if rv.Kind() == reflect.Interface && rv.Type() != valueType {
+		out, err := unmarshalTagged(v, path)
+		if err != nil {
+			return err
+		}
+		if out.IsValid() {
+			if !out.Type().AssignableTo(rv.Type()) {
+				return pathErrorf(path, "%v does not implement %v", out.Type(), rv.Type())
+			}
+			rv.Set(out)
+			return nil
+		}
+	}
+	if vt := reflect.TypeOf(v); vt.AssignableTo(rv.Type()) {
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if v.LuaType() == LUA_NIL {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	if ot, ok := v.(*OrderedTable); ok {
+		return unmarshalValue(ot.Table(), rv, path)
+	}
+	if rv.Type() == timeType {
+		return unmarshalTime(v, rv, path)
+	}
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return unmarshalValue(v, rv.Elem(), path)
+	case reflect.Bool:
+		b, ok := v.(Boolean)
+		if !ok {
+			return mismatch(path, "boolean", v)
+		}
+		rv.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInteger(v)
+		if !ok {
+			return mismatch(path, "integer", v)
+		}
+		if rv.OverflowInt(i) {
+			return pathErrorf(path, "integer %d overflows %v", i, rv.Type())
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := toInteger(v)
+		if !ok {
+			return mismatch(path, "integer", v)
+		}
+		if i < 0 || rv.OverflowUint(uint64(i)) {
+			return pathErrorf(path, "integer %d overflows %v", i, rv.Type())
+		}
+		rv.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		switch n := v.(type) {
+		case Real:
+			rv.SetFloat(float64(n))
+		case Integer:
+			rv.SetFloat(float64(n))
+		default:
+			return mismatch(path, "number", v)
+		}
+	case reflect.String:
+		s, ok := stringOf(v)
+		if !ok {
+			return mismatch(path, "string", v)
+		}
+		rv.SetString(s)
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			switch s := v.(type) {
+			case String:
+				rv.SetBytes([]byte(s))
+				return nil
+			case Bytes:
+				rv.SetBytes(append([]byte(nil), s...))
+				return nil
+			}
+		}
+		t, ok := v.(Table)
+		if !ok {
+			return mismatch(path, "table", v)
+		}
+		seq := tableSequence(t)
+		slice := reflect.MakeSlice(rv.Type(), len(seq), len(seq))
+		for i, item := range seq {
+			if err := unmarshalValue(item, slice.Index(i), joinKey(path, Integer(i+1))); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	case reflect.Array:
+		t, ok := v.(Table)
+		if !ok {
+			return mismatch(path, "table", v)
+		}
+		seq := tableSequence(t)
+		if len(seq) > rv.Len() {
+			return pathErrorf(path, "array length %d exceeds %v", len(seq), rv.Type())
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i >= len(seq) {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+				continue
+			}
+			if err := unmarshalValue(seq[i], rv.Index(i), joinKey(path, Integer(i+1))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		t, ok := v.(Table)
+		if !ok {
+			return mismatch(path, "table", v)
+		}
+		return unmarshalMap(t, rv, path)
+	case reflect.Struct:
+		t, ok := v.(Table)
+		if !ok {
+			return mismatch(path, "table", v)
+		}
+		if want, ok := typeTag(rv.Type()); ok {
+			if got, ok := t.GetString(TYPE_FIELD); ok && got != want {
+				return pathErrorf(joinField(path, TYPE_FIELD), "expected type %q, got %q", want, got)
+			}
+		}
+		for _, f := range cachedFields(rv.Type()) {
+			fv, ok := t.Hash[String(f.name)]
+			if !ok {
+				continue
+			}
+			if err := unmarshalValue(fv, rv.FieldByIndex(f.index), joinField(path, f.name)); err != nil {
+				return err
+			}
+		}
+	default:
+		return pathErrorf(path, "unsupported go type: %v", rv.Type())
+	}
+	return nil
+}
+
+func unmarshalMap(t Table, rv reflect.Value, path string) error {
+	mt := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMapWithSize(mt, len(t.Array)+len(t.Hash)))
+	}
+	set := func(k Value, v Value) error {
+		key := reflect.New(mt.Key()).Elem()
+		if err := unmarshalValue(k, key, path); err != nil {
+			return pathErrorf(joinKey(path, k), "invalid key: %v", err)
+		}
+		elem := reflect.New(mt.Elem()).Elem()
+		if err := unmarshalValue(v, elem, joinKey(path, k)); err != nil {
+			return err
+		}
+		rv.SetMapIndex(key, elem)
+		return nil
+	}
+	for i, v := range t.Array {
+		if err := set(Integer(i+1), v); err != nil {
+			return err
+		}
+	}
+	for k, v := range t.Hash {
+		if err := set(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableSequence 返回 Array 部分以及 Hash 中紧接其后的连续整数键
+func tableSequence(t Table) []Value {
+	seq := t.Array
+	for i := len(t.Array) + 1; ; i++ {
+		v, ok := t.Hash[Integer(i)]
+		if !ok || v.LuaType() == LUA_NIL {
+			break
+		}
+		if len(seq) == len(t.Array) {
+			seq = append([]Value(nil), t.Array...)
+		}
+		seq = append(seq, v)
+	}
+	return seq
+}
+
+func toInteger(v Value) (int64, bool) {
+	switch n := v.(type) {
+	case Integer:
+		return int64(n), true
+	case Real:
+		f := float64(n)
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+func mismatch(path string, expected string, got Value) error {
+	return pathErrorf(path, "expected %s, got %s", expected, typeName(got))
+}
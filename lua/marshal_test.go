@@ -0,0 +1,138 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type httpOpts struct {
+	Method   string            `lua:"method"`
+	Headers  map[string]string `lua:"headers,omitempty"`
+	Body     string            `lua:"body,omitempty"`
+	NoBody   bool              `lua:"noBody"`
+	Timeout  *int              `lua:"timeout"`
+	internal int
+}
+
+type httpArgs struct {
+	Url  string   `lua:"url"`
+	Opts httpOpts `lua:"opts"`
+	Tags []string `lua:"tags"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	timeout := 5
+	args := httpArgs{
+		Url: "http://example.com",
+		Opts: httpOpts{
+			Method:  "GET",
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			Timeout: &timeout,
+		},
+		Tags: []string{"a", "b"},
+	}
+	v, err := Marshal(args)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	packed, err := Serialize([]Value{v})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	unpacked, err := Deserialize(packed)
+	if err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	var out httpArgs
+	if err := Unmarshal(unpacked[0], &out); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(args, out) {
+		t.Errorf("value not match: %+v != %+v", args, out)
+	}
+	opts := v.(Table).Hash[String("opts")].(Table)
+	if _, ok := opts.Hash[String("body")]; ok {
+		t.Errorf("omitempty field marshaled")
+	}
+}
+
+func TestUnmarshalErrorPath(t *testing.T) {
+	v := Table{
+		Hash: map[Value]Value{
+			String("opts"): Table{
+				Hash: map[Value]Value{
+					String("method"): String("GET"),
+					String("headers"): Table{
+						Hash: map[Value]Value{String("X"): Integer(1)},
+					},
+				},
+			},
+		},
+	}
+	var out httpArgs
+	err := Unmarshal(v, &out)
+	if err == nil {
+		t.Fatalf("unmarshal should fail")
+	}
+	expected := `opts.headers["X"]: expected string, got integer`
+	if err.Error() != expected {
+		t.Errorf("error not match: %q != %q", err.Error(), expected)
+	}
+}
+
+func TestUnmarshalSliceAndValue(t *testing.T) {
+	v := Table{
+		Array: []Value{Integer(1), Real(2)},
+		Hash:  map[Value]Value{Integer(3): Integer(3)},
+	}
+	var ints []int
+	if err := Unmarshal(v, &ints); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(ints, []int{1, 2, 3}) {
+		t.Errorf("value not match: %v", ints)
+	}
+	var raw Value
+	if err := Unmarshal(v, &raw); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if raw.LuaType() != LUA_TABLE {
+		t.Errorf("expected raw table, got %v", raw)
+	}
+	var u uint8
+	if err := Unmarshal(Integer(300), &u); err == nil {
+		t.Errorf("overflow not detected")
+	}
+}
+
+func TestMarshalCycle(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	n := &node{Name: "a"}
+	n.Next = &node{Name: "b", Next: n}
+	if _, err := Marshal(n); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+	var x any
+	x = &x
+	if _, err := Marshal(x); err == nil {
+		t.Errorf("expected cycle error for self pointer")
+	}
+	m := map[string]any{}
+	m["self"] = m
+	if _, err := Marshal(m); err == nil || !strings.Contains(err.Error(), "excessive nesting") {
+		t.Errorf("expected nesting error, got %v", err)
+	}
+	// 同一个指针出现在不同的分支中不是循环
+	shared := &node{Name: "shared"}
+	v, err := Marshal([]*node{shared, shared})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if name, _ := v.(Table).Array[1].(Table).GetString("Name"); name != "shared" {
+		t.Errorf("value not match: %v", v)
+	}
+}
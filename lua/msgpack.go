@@ -0,0 +1,340 @@
+package lua
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// Lua 对象与 MessagePack 之间的转换
+// 1. Integer 编码为 int/uint, Real 总是编码为 float64, 解码时 float32 也转换为 Real
+// 2. Bytes 编码为 bin, 合法 UTF-8 的 String 编码为 str, 否则编码为 bin; str 解码为 String, bin 解码为 Bytes
+// 3. 非空且只有 1..n 键的 Table 编码为 array, 其余 (包括空 Table) 编码为 map
+// 4. nil 与 Null 编码为 nil, nil 解码为 Null 以保留数组中的位置
+// 5. map 按键排序编码, OrderedTable 按插入顺序编码
+
+const maxMsgpackDepth = 1000
+
+func ToMsgpack(v Value) ([]byte, error) {
+	return appendMsgpack(nil, v, 0)
+}
+
+func appendMsgpack(buf []byte, v Value, depth int) ([]byte, error) {
+	if v == nil {
+		v = Nil{}
+	}
+	switch val := v.(type) {
+	case Nil:
+		return append(buf, 0xc0), nil
+	case Boolean:
+		if val {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case Integer:
+		return appendMsgpackInteger(buf, int64(val)), nil
+	case Real:
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(float64(val))), nil
+	case String:
+		if !utf8.ValidString(string(val)) {
+			return appendMsgpackBinary(buf, []byte(val)), nil
+		}
+		return appendMsgpackString(buf, string(val)), nil
+	case Bytes:
+		return appendMsgpackBinary(buf, val), nil
+	case LightUserdata:
+		if val != Null {
+			return nil, fmt.Errorf("cannot encode userdata to msgpack")
+		}
+		return append(buf, 0xc0), nil
+	case Table, *OrderedTable:
+		if ot, ok := val.(*OrderedTable); ok && ot.Err() != nil {
+			return nil, ot.Err()
+		}
+		if depth >= maxMsgpackDepth {
+			return nil, fmt.Errorf("cannot encode to msgpack, excessive nesting (%d)", depth+1)
+		}
+		return appendMsgpackTable(buf, val, depth+1)
+	}
+	return nil, fmt.Errorf("cannot encode %s to msgpack", typeName(v))
+}
+
+func appendMsgpackInteger(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 0x7f:
+		return append(buf, byte(n))
+	case n >= -32 && n < 0:
+		return append(buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	case n >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+}
+
+func appendMsgpackBinary(buf []byte, b []byte) []byte {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(n))
+	}
+	return append(buf, b...)
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendMsgpackHeader(buf []byte, n int, fix byte, code16 byte) []byte {
+	switch {
+	case n <= 15:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, code16+1), uint32(n))
+}
+
+func appendMsgpackTable(buf []byte, v Value, depth int) ([]byte, error) {
+	t, _ := asTable(v)
+	seq, ok := t.Sequence()
+	if ok && len(seq) > 0 {
+		buf = appendMsgpackHeader(buf, len(seq), 0x90, 0xdc)
+		for _, item := range seq {
+			var err error
+			if buf, err = appendMsgpack(buf, item, depth); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	var pairs []Pair
+	if ot, ok := v.(*OrderedTable); ok {
+		for i, item := range ot.Array {
+			pairs = append(pairs, Pair{Integer(i + 1), item})
+		}
+		pairs = append(pairs, ot.Pairs...)
+	} else {
+		nt := Normalize(t)
+		for i, item := range nt.Array {
+			pairs = append(pairs, Pair{Integer(i + 1), item})
+		}
+		for _, k := range sortedKeys(nt.Hash) {
+			pairs = append(pairs, Pair{k, nt.Hash[k]})
+		}
+	}
+	buf = appendMsgpackHeader(buf, len(pairs), 0x80, 0xde)
+	for _, p := range pairs {
+		var err error
+		if buf, err = appendMsgpack(buf, p.Key, depth); err != nil {
+			return nil, err
+		}
+		if buf, err = appendMsgpack(buf, p.Value, depth); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func FromMsgpack(data []byte) (Value, error) {
+	dec := msgpackDecoder{data: data}
+	v, err := dec.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if dec.offset != len(data) {
+		return nil, fmt.Errorf("msgpack data has trailing content at offset %d", dec.offset)
+	}
+	return v, nil
+}
+
+type msgpackDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.offset < n {
+		return nil, fmt.Errorf("msgpack data truncated at offset %d: %w", d.offset, io.ErrUnexpectedEOF)
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+// readUint 读取 n 字节的大端序无符号整数
+func (d *msgpackDecoder) readUint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) readString(n int) (Value, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return String(b), nil
+}
+
+func (d *msgpackDecoder) readBinary(n int) (Value, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return Bytes(append([]byte(nil), b...)), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (Value, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	code := b[0]
+	switch {
+	case code <= 0x7f:
+		return Integer(code), nil
+	case code >= 0xe0:
+		return Integer(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		return d.readString(int(code & 0x1f))
+	case code&0xf0 == 0x90:
+		return d.decodeArray(int(code&0x0f), depth)
+	case code&0xf0 == 0x80:
+		return d.decodeMap(int(code&0x0f), depth)
+	}
+
+	switch code {
+	case 0xc0:
+		return Null, nil
+	case 0xc2:
+		return Boolean(false), nil
+	case 0xc3:
+		return Boolean(true), nil
+	case 0xca:
+		u, err := d.readUint(4)
+		return Real(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.readUint(8)
+		return Real(math.Float64frombits(u)), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		// uint64 超过 int64 范围时与 Lua 整数一样回绕
+		u, err := d.readUint(1 << (code - 0xcc))
+		return Integer(u), err
+	case 0xd0:
+		u, err := d.readUint(1)
+		return Integer(int8(u)), err
+	case 0xd1:
+		u, err := d.readUint(2)
+		return Integer(int16(u)), err
+	case 0xd2:
+		u, err := d.readUint(4)
+		return Integer(int32(u)), err
+	case 0xd3:
+		u, err := d.readUint(8)
+		return Integer(u), err
+	case 0xc4, 0xc5, 0xc6:
+		return d.decodeSized(1<<(code-0xc4), d.readBinary)
+	case 0xd9, 0xda, 0xdb:
+		return d.decodeSized(1<<(code-0xd9), d.readString)
+	case 0xdc, 0xdd:
+		return d.decodeSized(2<<(code-0xdc), func(n int) (Value, error) {
+			return d.decodeArray(n, depth)
+		})
+	case 0xde, 0xdf:
+		return d.decodeSized(2<<(code-0xde), func(n int) (Value, error) {
+			return d.decodeMap(n, depth)
+		})
+	}
+	return nil, fmt.Errorf("unsupported msgpack type 0x%02x at offset %d", code, d.offset-1)
+}
+
+func (d *msgpackDecoder) decodeSized(sizeLen int, fn func(n int) (Value, error)) (Value, error) {
+	n, err := d.readUint(sizeLen)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)-d.offset) {
+		return nil, fmt.Errorf("msgpack size %d exceeds remaining data at offset %d: %w", n, d.offset, io.ErrUnexpectedEOF)
+	}
+	return fn(int(n))
+}
+
+func (d *msgpackDecoder) decodeArray(n int, depth int) (Value, error) {
+	if depth >= maxMsgpackDepth {
+		return nil, fmt.Errorf("msgpack data too deep (%d)", depth+1)
+	}
+	array := make([]Value, 0, min(n, len(d.data)-d.offset))
+	for i := 0; i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return Table{Array: array}, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int, depth int) (Value, error) {
+	if depth >= maxMsgpackDepth {
+		return nil, fmt.Errorf("msgpack data too deep (%d)", depth+1)
+	}
+	hash := make(map[Value]Value, min(n, len(d.data)-d.offset))
+	for i := 0; i < n; i++ {
+		keyOffset := d.offset
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if k == Null {
+			return nil, fmt.Errorf("msgpack map key at offset %d can't be nil", keyOffset)
+		}
+		// bin 键先转换为 String 再检查
+		k = normalizeKey(k)
+		if err := checkTableKey(k); err != nil {
+			return nil, fmt.Errorf("msgpack map key at offset %d: %w", keyOffset, err)
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		hash[k] = v
+	}
+	return Normalize(Table{Hash: hash}), nil
+}
@@ -0,0 +1,86 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestMsgpack(t *testing.T) {
+	cases := []struct {
+		value   Value
+		encoded string
+	}{
+		{Nil{}, "c0"},
+		{Boolean(true), "c3"},
+		{Integer(1), "01"},
+		{Integer(-1), "ff"},
+		{Integer(200), "ccc8"},
+		{Integer(-200), "d1ff38"},
+		{Integer(math.MaxInt64), "cf7fffffffffffffff"},
+		{Real(1), "cb3ff0000000000000"},
+		{String("hi"), "a26869"},
+		{String("\xff\x00"), "c402ff00"},
+		{Table{Array: []Value{Integer(1), String("a")}}, "9201a161"},
+		{Table{}, "80"},
+		{Table{Array: []Value{Integer(1)}, Hash: map[Value]Value{String("b"): Boolean(false), String("a"): Null}}, "830101a161c0a162c2"},
+	}
+	for _, c := range cases {
+		encoded, err := ToMsgpack(c.value)
+		if err != nil {
+			t.Fatalf("encode %v failed: %v", c.value, err)
+		}
+		expected, _ := hex.DecodeString(c.encoded)
+		if !bytes.Equal(encoded, expected) {
+			t.Errorf("encode %v not match: %x != %x", c.value, encoded, expected)
+		}
+	}
+
+	value := Table{
+		Array: []Value{Integer(1), Real(1), Null, String("\x00\x01binary")},
+		Hash: map[Value]Value{
+			String("nested"): Table{Hash: map[Value]Value{Integer(-5): Real(0.5)}},
+			Real(1.5):        Boolean(true),
+		},
+	}
+	encoded, err := ToMsgpack(value)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	decoded, err := FromMsgpack(encoded)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !Equal(decoded, value) {
+		t.Errorf("value not match: %v != %v", decoded, value)
+	}
+
+	if _, err := ToMsgpack(LightUserdata(1)); err == nil {
+		t.Errorf("expected userdata error")
+	}
+	if _, err := FromMsgpack(encoded[:len(encoded)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected truncated error, got %v", err)
+	}
+	if _, err := FromMsgpack([]byte{0x81, 0xc0, 0x01}); err == nil {
+		t.Errorf("expected nil key error")
+	}
+	if _, err := FromMsgpack([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Errorf("expected size error")
+	}
+	if v, err := FromMsgpack([]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}); err != nil || v != Real(1.5) {
+		t.Errorf("float32 not match: %v %v", v, err)
+	}
+}
+
+func TestMsgpackBinaryKey(t *testing.T) {
+	// {bin("key"): 1}
+	data, _ := hex.DecodeString("81c4036b657901")
+	v, err := FromMsgpack(data)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	AssertEqual(t, Table{Hash: map[Value]Value{String("key"): Integer(1)}}, v)
+}
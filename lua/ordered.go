@@ -0,0 +1,164 @@
+package lua
+
+import "fmt"
+
+// OrderedTable 按插入顺序保存哈希部分, 序列化时也按该顺序输出,
+// 用于在 Go 中构造返回给 Skynet 的 Table:
+//
+//	lua.NewTable().Set("code", 200).Append("a", "b")
+type OrderedTable struct {
+	Array []Value
+	Pairs []Pair
+
+	index map[Value]int // 键较多时才建立索引
+	err   error
+}
+
+type Pair struct {
+	Key   Value
+	Value Value
+}
+
+const orderedIndexThreshold = 8
+
+func NewTable() *OrderedTable {
+	return &OrderedTable{}
+}
+
+func (t *OrderedTable) LuaType() uint8 {
+	return LUA_TABLE
+}
+
+func toValue(v any) (Value, error) {
+	if lv, ok := v.(Value); ok {
+		return lv, nil
+	}
+	return Marshal(v)
+}
+
+func (t *OrderedTable) find(k Value) int {
+	if t.index != nil {
+		if i, ok := t.index[k]; ok {
+			return i
+		}
+		return -1
+	}
+	for i, p := range t.Pairs {
+		if p.Key == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// Set 设置键值, 已存在的键保持原来的位置, 值为 nil 时删除该键.
+// 转换失败的错误保存在 Err 中, 并在序列化时返回
+func (t *OrderedTable) Set(key any, value any) *OrderedTable {
+	if t.err != nil {
+		return t
+	}
+	k := toKey(key)
+	if _, ok := key.(Value); !ok && key != nil && isNil(k) {
+		t.err = fmt.Errorf("unsupported table key type: %T", key)
+		return t
+	}
+	if isNil(k) || k.LuaType() == LUA_TABLE {
+		t.err = fmt.Errorf("table key can't be table, array or nil: %v", key)
+		return t
+	}
+	v, err := toValue(value)
+	if err != nil {
+		t.err = pathErrorf(joinKey("", k), "%v", err)
+		return t
+	}
+	i := t.find(k)
+	if isNil(v) {
+		if i >= 0 {
+			t.Pairs = append(t.Pairs[:i], t.Pairs[i+1:]...)
+			t.reindex()
+		}
+		return t
+	}
+	if i >= 0 {
+		t.Pairs[i].Value = v
+		return t
+	}
+	t.Pairs = append(t.Pairs, Pair{Key: k, Value: v})
+	if t.index != nil {
+		t.index[k] = len(t.Pairs) - 1
+	} else if len(t.Pairs) > orderedIndexThreshold {
+		t.reindex()
+	}
+	return t
+}
+
+func (t *OrderedTable) reindex() {
+	if len(t.Pairs) <= orderedIndexThreshold {
+		t.index = nil
+		return
+	}
+	t.index = make(map[Value]int, len(t.Pairs))
+	for i, p := range t.Pairs {
+		t.index[p.Key] = i
+	}
+}
+
+// Append 向数组部分追加元素
+func (t *OrderedTable) Append(values ...any) *OrderedTable {
+	if t.err != nil {
+		return t
+	}
+	for _, value := range values {
+		v, err := toValue(value)
+		if err == nil && isNil(v) {
+			err = fmt.Errorf("array value can't be nil")
+		}
+		if err != nil {
+			t.err = pathErrorf(joinKey("", Integer(len(t.Array)+1)), "%v", err)
+			return t
+		}
+		t.Array = append(t.Array, v)
+	}
+	return t
+}
+
+func (t *OrderedTable) Get(key any) (Value, bool) {
+	if i := t.find(toKey(key)); i >= 0 {
+		return t.Pairs[i].Value, true
+	}
+	return Nil{}, false
+}
+
+func (t *OrderedTable) Err() error {
+	return t.err
+}
+
+// Table 转换为普通的 Table, 丢弃顺序信息
+func (t *OrderedTable) Table() Table {
+	hash := make(map[Value]Value, len(t.Pairs))
+	for _, p := range t.Pairs {
+		hash[p.Key] = p.Value
+	}
+	return Table{
+		Array: t.Array,
+		Hash:  hash,
+	}
+}
+
+func (t *OrderedTable) String() string {
+	return Format(t)
+}
+
+// asTable 将 OrderedTable 转换为 Table, 用于不关心顺序的场景
+func asTable(v Value) (Table, bool) {
+	switch t := v.(type) {
+	case Table:
+		return t, true
+	case *OrderedTable:
+		if t == nil {
+			return Table{}, false
+		}
+		return t.Table(), true
+	}
+	return Table{}, false
+}
@@ -0,0 +1,101 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOrderedTable(t *testing.T) {
+	table := NewTable().
+		Set("code", 200).
+		Set("msg", "ok").
+		Append("a", Integer(2)).
+		Set("headers", NewTable().Set("b", "1").Set("a", "2")).
+		Set("msg", "hello")
+	if err := table.Err(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	expected := `{"a", 2, code = 200, msg = "hello", headers = {b = "1", a = "2"}}`
+	if s := Format(table); s != expected {
+		t.Errorf("format not match:\n%s\n%s", s, expected)
+	}
+
+	packed, err := Serialize([]Value{table})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		again, _ := Serialize([]Value{table})
+		if !bytes.Equal(packed, again) {
+			t.Fatalf("ordered serialization is not stable")
+		}
+	}
+	unpacked, err := Deserialize(packed)
+	if err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	if !Equal(unpacked[0], table) {
+		t.Errorf("value not match: %v != %v", unpacked[0], table)
+	}
+
+	table.Set("code", nil)
+	if _, ok := table.Get("code"); ok || len(table.Pairs) != 2 {
+		t.Errorf("delete failed: %v", table)
+	}
+	for i := 0; i < 20; i++ {
+		table.Set(i, i)
+	}
+	if v, ok := table.Get(15); !ok || v != Integer(15) {
+		t.Errorf("get failed: %v %v", v, ok)
+	}
+
+	bad := NewTable().Set("x", make(chan int))
+	if _, err := Serialize([]Value{bad}); err == nil {
+		t.Errorf("invalid value should fail")
+	}
+}
+
+func TestOrderedTableInvalidPairs(t *testing.T) {
+	// 直接填充的 Pairs 和 Array 与 Table 一样检查
+	invalid := []*OrderedTable{
+		{Pairs: []Pair{{Key: Nil{}, Value: Integer(1)}}},
+		{Pairs: []Pair{{Key: nil, Value: Integer(1)}}},
+		{Pairs: []Pair{{Key: String("a"), Value: Nil{}}}},
+		{Pairs: []Pair{{Key: String("a"), Value: nil}}},
+		{Pairs: []Pair{{Key: Table{}, Value: Integer(1)}}},
+		{Array: []Value{Integer(1), nil}},
+	}
+	for i, ot := range invalid {
+		if _, err := Serialize([]Value{ot}); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+	packed, err := Serialize([]Value{&OrderedTable{Pairs: []Pair{{Key: String("a"), Value: Integer(1)}}}})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	unpacked, _ := Deserialize(packed)
+	AssertEqual(t, Table{Hash: map[Value]Value{String("a"): Integer(1)}}, unpacked[0])
+}
+
+func TestOrderedTableNil(t *testing.T) {
+	err := NewTable().Set(uint64(1), "x").Err()
+	if err == nil || !strings.Contains(err.Error(), "uint64") {
+		t.Errorf("expected unsupported key type error, got %v", err)
+	}
+
+	var ot *OrderedTable
+	packed, err := Serialize([]Value{ot})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	unpacked, _ := Deserialize(packed)
+	AssertEqual(t, Nil{}, unpacked[0])
+	if v, err := Marshal(ot); err != nil || v != (Nil{}) {
+		t.Errorf("marshal not match: %#v %v", v, err)
+	}
+	if _, err := Serialize([]Value{Table{Array: []Value{ot}}}); err == nil {
+		t.Errorf("expected nil array value error")
+	}
+}
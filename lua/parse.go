@@ -8,11 +8,11 @@ func MustNil(v Value) {
 }
 
 func MustString(v Value) string {
-	s, ok := v.(String)
+	s, ok := stringOf(v)
 	if !ok {
 		panic("not a string")
 	}
-	return string(s)
+	return s
 }
 
 func MustBoolean(v Value) bool {
@@ -46,3 +46,176 @@ func MustTable(v Value) Table {
 	}
 	return t
 }
+
+// 不会 panic 的 Table 访问方法, key 可以是 Value, string 或整数
+func toKey(key any) Value {
+	switch k := key.(type) {
+	case Value:
+		return normalizeKey(k)
+	case string:
+		return String(k)
+	case int:
+		return Integer(k)
+	case int64:
+		return Integer(k)
+	case int32:
+		return Integer(k)
+	case uint32:
+		return Integer(k)
+	case float64:
+		return normalizeKey(Real(k))
+	case bool:
+		return Boolean(k)
+	default:
+		return Nil{}
+	}
+}
+
+// Get 按 Lua 的规则查找键, 整数键先在数组部分查找
+func (t Table) Get(key any) (Value, bool) {
+	k := toKey(key)
+	if i, ok := k.(Integer); ok && i >= 1 && int64(i) <= int64(len(t.Array)) {
+		v := t.Array[i-1]
+		return v, v != nil && v.LuaType() != LUA_NIL
+	}
+	if k.LuaType() == LUA_NIL {
+		return Nil{}, false
+	}
+	v, ok := t.Hash[k]
+	if !ok || v == nil || v.LuaType() == LUA_NIL {
+		return Nil{}, false
+	}
+	return v, true
+}
+
+func (t Table) GetString(key any) (string, bool) {
+	v, _ := t.Get(key)
+	return stringOf(v)
+}
+
+func (t Table) GetBool(key any) (bool, bool) {
+	v, _ := t.Get(key)
+	b, ok := v.(Boolean)
+	return bool(b), ok
+}
+
+// GetInt 同时接受值为整数的浮点数
+func (t Table) GetInt(key any) (int64, bool) {
+	v, ok := t.Get(key)
+	if !ok {
+		return 0, false
+	}
+	return toInteger(v)
+}
+
+func (t Table) GetNumber(key any) (float64, bool) {
+	v, _ := t.Get(key)
+	switch n := v.(type) {
+	case Integer:
+		return float64(n), true
+	case Real:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func (t Table) GetTable(key any) (Table, bool) {
+	v, _ := t.Get(key)
+	tt, ok := v.(Table)
+	return tt, ok
+}
+
+func (t Table) StringOr(key any, def string) string {
+	if s, ok := t.GetString(key); ok {
+		return s
+	}
+	return def
+}
+
+func (t Table) BoolOr(key any, def bool) bool {
+	if b, ok := t.GetBool(key); ok {
+		return b
+	}
+	return def
+}
+
+func (t Table) IntOr(key any, def int64) int64 {
+	if i, ok := t.GetInt(key); ok {
+		return i
+	}
+	return def
+}
+
+// Len 返回数组部分以及哈希部分中紧接其后的连续整数键的个数, 与 Lua 的 # 一致
+func (t Table) Len() int {
+	n := len(t.Array)
+	for n > 0 && (t.Array[n-1] == nil || t.Array[n-1].LuaType() == LUA_NIL) {
+		n--
+	}
+	if n < len(t.Array) {
+		return n
+	}
+	for {
+		v, ok := t.Hash[Integer(n+1)]
+		if !ok || v == nil || v.LuaType() == LUA_NIL {
+			return n
+		}
+		n++
+	}
+}
+
+// Index 与 Lua 相同, 下标从 1 开始
+func (t Table) Index(i int) (Value, bool) {
+	return t.Get(Integer(i))
+}
+
+// Query 按路径查找嵌套的值, 例如 opts.headers['Content-Type'] 或 list[1].name,
+// 失败时返回的 PathError 指出出错的路径
+func Query(v Value, path string) (Value, error) {
+	p := &literalParser{src: path}
+	cur := v
+	walked := ""
+	for first := true; ; first = false {
+		p.skipSpace()
+		if p.eof() {
+			return cur, nil
+		}
+		var key Value
+		switch {
+		case p.peek() == '[':
+			p.pos++
+			k, err := p.parseExpr(0)
+			if err != nil {
+				return nil, pathErrorf(walked, "invalid path %q: %v", path, err)
+			}
+			if !p.accept("]") {
+				return nil, pathErrorf(walked, "invalid path %q: ']' expected", path)
+			}
+			key = normalizeKey(k)
+		case first || p.accept("."):
+			p.skipSpace()
+			name := p.readName()
+			if !isIdentifier(name) {
+				return nil, pathErrorf(walked, "invalid path %q: name expected", path)
+			}
+			key = String(name)
+		default:
+			return nil, pathErrorf(walked, "invalid path %q: unexpected %q", path, p.peekToken())
+		}
+		var next string
+		if s, ok := key.(String); ok && isIdentifier(string(s)) {
+			next = joinField(walked, string(s))
+		} else {
+			next = joinKey(walked, key)
+		}
+		t, ok := asTable(cur)
+		if !ok {
+			return nil, pathErrorf(next, "attempt to index a %s value", typeName(cur))
+		}
+		cur, ok = t.Get(key)
+		if !ok {
+			return nil, pathErrorf(next, "not found")
+		}
+		walked = next
+	}
+}
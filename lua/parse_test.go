@@ -0,0 +1,151 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestTableAccessors(t *testing.T) {
+	table := Table{
+		Array: []Value{String("a"), String("b")},
+		Hash: map[Value]Value{
+			Integer(3):        String("c"),
+			String("method"):  String("GET"),
+			String("timeout"): Real(5),
+			String("noBody"):  Boolean(true),
+			String("headers"): Table{Hash: map[Value]Value{String("Content-Type"): String("text/plain")}},
+		},
+	}
+	if s, ok := table.GetString("method"); !ok || s != "GET" {
+		t.Errorf("get string failed: %v %v", s, ok)
+	}
+	if _, ok := table.GetString("timeout"); ok {
+		t.Errorf("get string on real should fail")
+	}
+	if i, ok := table.GetInt("timeout"); !ok || i != 5 {
+		t.Errorf("get int failed: %v %v", i, ok)
+	}
+	if b := table.BoolOr("noHeader", true); !b {
+		t.Errorf("bool default not used")
+	}
+	if v, ok := table.Index(3); !ok || v != String("c") {
+		t.Errorf("index failed: %v %v", v, ok)
+	}
+	if _, ok := table.Index(4); ok {
+		t.Errorf("index out of range should fail")
+	}
+	if n := table.Len(); n != 3 {
+		t.Errorf("len not match: %d", n)
+	}
+	if h, ok := table.GetTable("headers"); !ok || h.StringOr("Content-Type", "") != "text/plain" {
+		t.Errorf("get table failed: %v %v", h, ok)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	args := Table{
+		Hash: map[Value]Value{
+			String("opts"): Table{
+				Hash: map[Value]Value{
+					String("headers"): Table{Hash: map[Value]Value{String("Content-Type"): String("text/plain")}},
+					String("list"):    Table{Array: []Value{Table{Hash: map[Value]Value{String("name"): String("x")}}}},
+				},
+			},
+		},
+	}
+	v, err := Query(args, "opts.headers['Content-Type']")
+	if err != nil || v != String("text/plain") {
+		t.Errorf("query failed: %v %v", v, err)
+	}
+	v, err = Query(args, `opts["list"][1].name`)
+	if err != nil || v != String("x") {
+		t.Errorf("query failed: %v %v", v, err)
+	}
+	cases := map[string]string{
+		"opts.body":                      "opts.body: not found",
+		"opts.headers.X.Y":               "opts.headers.X: not found",
+		"opts.list[1].name.first":        "opts.list[1].name.first: attempt to index a string value",
+		"opts.headers['Content-Type'].x": `opts.headers["Content-Type"].x: attempt to index a string value`,
+	}
+	for path, expected := range cases {
+		_, err := Query(args, path)
+		if err == nil || err.Error() != expected {
+			t.Errorf("query %s error not match: %v != %s", path, err, expected)
+		}
+	}
+	if _, err := Query(args, "opts..x"); err == nil {
+		t.Errorf("invalid path should fail")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	table := Table{
+		Array: []Value{Integer(1), Integer(2), Nil{}, Integer(4)},
+		Hash: map[Value]Value{
+			Real(3):        Integer(3),
+			Integer(5):     Integer(5),
+			Integer(7):     Integer(7),
+			String("name"): String("x"),
+			String("none"): Nil{},
+		},
+	}
+	normalized := Normalize(table)
+	if len(normalized.Array) != 5 {
+		t.Fatalf("array not match: %v", normalized)
+	}
+	for i, v := range normalized.Array {
+		if v != Integer(i+1) {
+			t.Errorf("array value not match: %v", normalized.Array)
+		}
+	}
+	if len(normalized.Hash) != 2 || normalized.Hash[Integer(7)] != Integer(7) {
+		t.Errorf("hash not match: %v", normalized.Hash)
+	}
+
+	seq, ok := table.Sequence()
+	if ok || len(seq) != 2 {
+		t.Errorf("sequence not match: %v %v", seq, ok)
+	}
+	seq, ok = Table{Array: []Value{Integer(1)}, Hash: map[Value]Value{Integer(2): Integer(2)}}.Sequence()
+	if !ok || len(seq) != 2 {
+		t.Errorf("sequence not match: %v %v", seq, ok)
+	}
+
+	count := 0
+	normalized.Pairs(func(k, v Value) bool {
+		count++
+		return true
+	})
+	if count != 7 {
+		t.Errorf("pairs count not match: %d", count)
+	}
+}
+
+func TestPairsKeys(t *testing.T) {
+	pairs := func(table Table) map[Value]Value {
+		got := make(map[Value]Value)
+		table.Pairs(func(k, v Value) bool {
+			if _, ok := got[k]; ok {
+				t.Errorf("duplicate key: %v", k)
+			}
+			got[k] = v
+			return true
+		})
+		return got
+	}
+	// 浮点数键与数组部分重复
+	got := pairs(Table{
+		Array: []Value{String("a")},
+		Hash:  map[Value]Value{Real(1): String("b"), Real(2): String("c"), Real(2.5): String("d")},
+	})
+	if len(got) != 3 || got[Integer(1)] != String("a") || got[Integer(2)] != String("c") || got[Real(2.5)] != String("d") {
+		t.Errorf("pairs not match: %v", got)
+	}
+	// 哈希部分补上数组部分的空洞
+	got = pairs(Table{
+		Array: []Value{String("a"), Nil{}, String("c")},
+		Hash:  map[Value]Value{Integer(2): String("b"), Integer(3): String("x")},
+	})
+	if len(got) != 3 || got[Integer(2)] != String("b") || got[Integer(3)] != String("c") {
+		t.Errorf("pairs not match: %v", got)
+	}
+}
@@ -0,0 +1,119 @@
+package lua
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// skynet.pack 不保留元表, Lua 代码以 __type 字段标记对象的类型:
+//
+//	{ __type = "move", x = 1, y = 2 }
+//
+// RegisterType 将类型标记与 Go 结构体关联后:
+// 1. Marshal 注册过的结构体时自动写入 __type 字段
+// 2. Unmarshal 到接口类型 (lua.Value 除外) 时, 按 __type 创建对应的结构体, 未注册的标记按原样赋值
+// 3. Unmarshal 到注册过的结构体时, __type 不一致返回错误
+
+const TYPE_FIELD = "__type"
+
+type registeredType struct {
+	typ     reflect.Type // 结构体类型
+	pointer bool         // 注册时使用的是指针, 解码结果也是指针
+}
+
+var registry = struct {
+	sync.RWMutex
+	types map[string]registeredType
+	tags  map[reflect.Type]string
+}{
+	types: make(map[string]registeredType),
+	tags:  make(map[reflect.Type]string),
+}
+
+// RegisterType 注册类型标记 tag 对应的结构体, v 为结构体或结构体指针.
+// 与 gob.Register 相同, 重复注册会 panic
+func RegisterType(tag string, v any) {
+	rt := reflect.TypeOf(v)
+	pointer := false
+	if rt != nil && rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+		pointer = true
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("lua: RegisterType %q: %T is not a struct", tag, v))
+	}
+	if tag == "" {
+		panic(fmt.Sprintf("lua: RegisterType %v: empty tag", rt))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if old, ok := registry.types[tag]; ok {
+		panic(fmt.Sprintf("lua: RegisterType %q: already registered for %v", tag, old.typ))
+	}
+	if old, ok := registry.tags[rt]; ok {
+		panic(fmt.Sprintf("lua: RegisterType %v: already registered as %q", rt, old))
+	}
+	registry.types[tag] = registeredType{typ: rt, pointer: pointer}
+	registry.tags[rt] = tag
+}
+
+func typeTag(rt reflect.Type) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	tag, ok := registry.tags[rt]
+	return tag, ok
+}
+
+func lookupType(tag string) (registeredType, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	rt, ok := registry.types[tag]
+	return rt, ok
+}
+
+// TableTypeTag 返回 Table 的 __type 字段
+func TableTypeTag(v Value) (string, bool) {
+	t, ok := asTable(v)
+	if !ok {
+		return "", false
+	}
+	return t.GetString(TYPE_FIELD)
+}
+
+// UnmarshalTagged 按 __type 创建注册过的结构体并解码, 返回值的类型与注册时相同
+func UnmarshalTagged(v Value) (any, error) {
+	tag, ok := TableTypeTag(v)
+	if !ok {
+		return nil, mismatch("", "tagged table", v)
+	}
+	out, err := unmarshalTagged(v, "")
+	if err != nil {
+		return nil, err
+	}
+	if !out.IsValid() {
+		return nil, pathErrorf(TYPE_FIELD, "unregistered type %q", tag)
+	}
+	return out.Interface(), nil
+}
+
+// unmarshalTagged 返回按 __type 解码的结果, 没有注册的标记时返回无效的 reflect.Value
+func unmarshalTagged(v Value, path string) (reflect.Value, error) {
+	tag, ok := TableTypeTag(v)
+	if !ok {
+		return reflect.Value{}, nil
+	}
+	rt, ok := lookupType(tag)
+	if !ok {
+		return reflect.Value{}, nil
+	}
+	out := reflect.New(rt.typ)
+	if err := unmarshalValue(v, out.Elem(), path); err != nil {
+		return reflect.Value{}, err
+	}
+	if rt.pointer {
+		return out, nil
+	}
+	return out.Elem(), nil
+}
@@ -0,0 +1,76 @@
+package lua
+
+import (
+	"testing"
+)
+
+type testCommand interface {
+	command() string
+}
+
+type testMove struct {
+	X int `lua:"x"`
+	Y int `lua:"y"`
+}
+
+func (m *testMove) command() string { return "move" }
+
+type testChat struct {
+	Text string `lua:"text"`
+}
+
+func (c testChat) command() string { return "chat" }
+
+func init() {
+	RegisterType("move", &testMove{})
+	RegisterType("chat", testChat{})
+}
+
+func TestRegisterType(t *testing.T) {
+	v, err := Marshal([]testCommand{&testMove{X: 1, Y: 2}, testChat{Text: "hi"}})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	AssertEqual(t, Table{Array: []Value{
+		Table{Hash: map[Value]Value{String("__type"): String("move"), String("x"): Integer(1), String("y"): Integer(2)}},
+		Table{Hash: map[Value]Value{String("__type"): String("chat"), String("text"): String("hi")}},
+	}}, v)
+
+	packed, _ := Serialize([]Value{v})
+	unpacked, _ := Deserialize(packed)
+	var cmds []testCommand
+	if err := Unmarshal(unpacked[0], &cmds); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(cmds) != 2 || cmds[0].command() != "move" || cmds[0].(*testMove).Y != 2 || cmds[1].(testChat).Text != "hi" {
+		t.Errorf("commands not match: %#v", cmds)
+	}
+
+	tagged, err := UnmarshalTagged(unpacked[0].(Table).Array[1])
+	if err != nil || tagged.(testChat).Text != "hi" {
+		t.Errorf("unmarshal tagged failed: %v %v", tagged, err)
+	}
+	if _, err := UnmarshalTagged(Table{Hash: map[Value]Value{String("__type"): String("unknown")}}); err == nil {
+		t.Errorf("expected unregistered type error")
+	}
+
+	var move testMove
+	err = Unmarshal(unpacked[0].(Table).Array[1], &move)
+	if err == nil || err.Error() != `__type: expected type "move", got "chat"` {
+		t.Errorf("expected type mismatch, got %v", err)
+	}
+
+	// 未注册的标记按原样保留
+	var raw any
+	unknown := Table{Hash: map[Value]Value{String("__type"): String("unknown")}}
+	if err := Unmarshal(unknown, &raw); err != nil || !Equal(raw.(Value), unknown) {
+		t.Errorf("unknown tag not kept: %v %v", raw, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected duplicate register panic")
+		}
+	}()
+	RegisterType("move", testChat{})
+}
@@ -2,49 +2,122 @@ package lua
 
 // Lua 对象在 Go 中的简单表示，用于序列化和反序列化
 // 1. 不支持元表的序列化/反序列化
-// 2. 不支持函数, 线程, 用户数据, 轻量用户数据的序列化/反序列化
-//    (LUA_TFUNCTION, LUA_TTHREAD, LUA_TUSERDATA, LUA_TLIGHTUSERDATA)
-// 3. 支持纯 Lua Table 数组与 Go Slice 的序列化/反序列化
+// 2. 不支持函数, 线程, 用户数据的序列化/反序列化
+//    (LUA_TFUNCTION, LUA_TTHREAD, LUA_TUSERDATA)
+// 3. 轻量用户数据以 LightUserdata 保存指针的值, 原样传递
+// 4. 支持纯 Lua Table 数组与 Go Slice 的序列化/反序列化
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sync"
+	"unsafe"
 )
 
 const MAX_COOKIE = 32
 
+type byteWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
 func combineType(t uint8, v uint8) uint8 {
 	return t | (v << 3)
 }
 
 func Serialize(values []Value) ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
+	return serialize(values, false)
+}
+
+// SerializeCanonical 与 Serialize 相同, 但哈希部分的键先按 Lua 类型再按值排序,
+// 相同的对象总是得到相同的字节
+func SerializeCanonical(values []Value) ([]byte, error) {
+	return serialize(values, true)
+}
+
+func serialize(values []Value, canonical bool) ([]byte, error) {
+	return appendSerialize(nil, values, canonical)
+}
+
+// AppendSerialize 将序列化结果追加到 dst, 配合 SerializedSize 可以复用或预先分配缓冲区
+func AppendSerialize(dst []byte, values []Value) ([]byte, error) {
+	return appendSerialize(dst, values, false)
+}
+
+func appendSerialize(dst []byte, values []Value, canonical bool) ([]byte, error) {
+	buf := &appendWriter{buf: dst}
 	for _, v := range values {
-		if err := serilizeLuaValue(buf, v, 0); err != nil {
+		if err := serilizeValue(buf, v, 0, canonical); err != nil {
 			return nil, err
 		}
 	}
-	return buf.Bytes(), nil
+	return buf.buf, nil
 }
 
-func Deserialize(data []byte) ([]Value, error) {
-	buf := bytes.NewBuffer(data)
-	values := make([]Value, 0)
-	for {
-		value, err := deserilizeLuaValue(buf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
+// SerializedSize 返回 Serialize 结果的字节数, 无法序列化时返回 -1
+func SerializedSize(values []Value) int {
+	var w sizeWriter
+	for _, v := range values {
+		if err := serilizeValue(&w, v, 0, false); err != nil {
+			return -1
 		}
-		values = append(values, value)
 	}
-	return values, nil
+	return int(w)
+}
+
+type appendWriter struct {
+	buf []byte
 }
 
-func serilizeLuaValue(buf *bytes.Buffer, v Value, depth int) error {
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *appendWriter) WriteByte(c byte) error {
+	w.buf = append(w.buf, c)
+	return nil
+}
+
+func (w *appendWriter) WriteString(s string) (int, error) {
+	w.buf = append(w.buf, s...)
+	return len(s), nil
+}
+
+// sizeWriter 只记录写入的字节数
+type sizeWriter int
+
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	*w += sizeWriter(len(p))
+	return len(p), nil
+}
+
+func (w *sizeWriter) WriteByte(c byte) error {
+	*w++
+	return nil
+}
+
+func (w *sizeWriter) WriteString(s string) (int, error) {
+	*w += sizeWriter(len(s))
+	return len(s), nil
+}
+
+func Deserialize(data []byte) ([]Value, error) {
+	return DeserializeWithOptions(data, DecodeOptions{})
+}
+
+func serilizeLuaValue(buf byteWriter, v Value, depth int) error {
+	return serilizeValue(buf, v, depth, false)
+}
+
+func serilizeValue(buf byteWriter, v Value, depth int, canonical bool) error {
 	if depth > 32 {
 		return fmt.Errorf("serialize can't pack too deep")
 	}
@@ -58,9 +131,30 @@ func serilizeLuaValue(buf *bytes.Buffer, v Value, depth int) error {
 	case LUA_REAL:
 		serilizeReal(buf, v.(Real))
 	case LUA_STRING:
-		serilizeString(buf, v.(String))
+		var err error
+		switch s := v.(type) {
+		case Bytes:
+			err = serilizeBytes(buf, s)
+		default:
+			err = serilizeString(buf, v.(String))
+		}
+		if err != nil {
+			return err
+		}
+	case LUA_LIGHTUSERDATA:
+		serilizeLightUserdata(buf, v.(LightUserdata))
 	case LUA_TABLE:
-		err := serilizeTable(buf, v.(Table), depth+1)
+		var err error
+		switch t := v.(type) {
+		case *OrderedTable:
+			if t == nil {
+				serilizeNil(buf)
+				break
+			}
+			err = serilizeOrderedTable(buf, t, depth+1, canonical)
+		default:
+			err = serilizeTableWith(buf, v.(Table), depth+1, canonical)
+		}
 		if err != nil {
 			return err
 		}
@@ -70,11 +164,11 @@ func serilizeLuaValue(buf *bytes.Buffer, v Value, depth int) error {
 	return nil
 }
 
-func serilizeNil(buf *bytes.Buffer) {
+func serilizeNil(buf byteWriter) {
 	buf.WriteByte(TYPE_NIL)
 }
 
-func serilizeBoolean(buf *bytes.Buffer, b Boolean) {
+func serilizeBoolean(buf byteWriter, b Boolean) {
 	if b {
 		buf.WriteByte(combineType(TYPE_BOOLEAN, 1))
 	} else {
@@ -82,24 +176,32 @@ func serilizeBoolean(buf *bytes.Buffer, b Boolean) {
 	}
 }
 
-func serilizeReal(buf *bytes.Buffer, v Real) {
+func serilizeReal(buf byteWriter, v Real) {
 	buf.WriteByte(combineType(TYPE_NUMBER, TYPE_NUMBER_REAL))
 	binary.Write(buf, binary.LittleEndian, v)
 }
 
-func serilizeTableArray(buf *bytes.Buffer, array []Value, depth int) error {
-	arrLen := len(array)
+func serilizeLightUserdata(buf byteWriter, v LightUserdata) {
+	buf.WriteByte(combineType(TYPE_USERDATA, 0))
+	binary.Write(buf, binary.LittleEndian, uint64(v))
+}
+
+func serilizeTableHeader(buf byteWriter, arrLen int) {
 	if arrLen >= MAX_COOKIE-1 {
 		buf.WriteByte(combineType(TYPE_TABLE, MAX_COOKIE-1))
 		serilizeInteger(buf, Integer(arrLen))
 	} else {
 		buf.WriteByte(combineType(TYPE_TABLE, uint8(arrLen)))
 	}
+}
+
+func serilizeTableArray(buf byteWriter, array []Value, depth int, canonical bool) error {
+	serilizeTableHeader(buf, len(array))
 	for _, v := range array {
-		if v.LuaType() == LUA_NIL {
+		if isNil(v) {
 			return fmt.Errorf("array value can't be nil")
 		}
-		err := serilizeLuaValue(buf, v, depth+1)
+		err := serilizeValue(buf, v, depth+1, canonical)
 		if err != nil {
 			return err
 		}
@@ -107,26 +209,30 @@ func serilizeTableArray(buf *bytes.Buffer, array []Value, depth int) error {
 	return nil
 }
 
-func serilizeTable(buf *bytes.Buffer, table Table, depth int) error {
+func serilizeTable(buf byteWriter, table Table, depth int) error {
+	return serilizeTableWith(buf, table, depth, false)
+}
+
+func serilizeTableWith(buf byteWriter, table Table, depth int, canonical bool) error {
 	if table.Array != nil && len(table.Array) > 0 {
-		serilizeTableArray(buf, table.Array, depth+1)
+		if err := serilizeTableArray(buf, table.Array, depth+1, canonical); err != nil {
+			return err
+		}
 	} else {
 		buf.WriteByte(combineType(TYPE_TABLE, 0))
 	}
-	if table.Hash != nil {
-		for k, v := range table.Hash {
-			if k.LuaType() == LUA_TABLE || k.LuaType() == LUA_NIL {
-				return fmt.Errorf("table key can't be table, array or nil")
-			}
-			if v.LuaType() == LUA_NIL {
-				return fmt.Errorf("table value can't be nil")
-			}
-			err := serilizeLuaValue(buf, k, depth+1)
-			if err != nil {
+	pair := func(k Value, v Value) error {
+		return serilizePair(buf, k, v, depth, canonical)
+	}
+	if canonical {
+		for _, k := range sortedKeys(table.Hash) {
+			if err := pair(k, table.Hash[k]); err != nil {
 				return err
 			}
-			err = serilizeLuaValue(buf, v, depth+1)
-			if err != nil {
+		}
+	} else {
+		for k, v := range table.Hash {
+			if err := pair(k, v); err != nil {
 				return err
 			}
 		}
@@ -135,26 +241,83 @@ func serilizeTable(buf *bytes.Buffer, table Table, depth int) error {
 	return nil
 }
 
-func serilizeString(buf *bytes.Buffer, luaString String) {
-	sz := len(luaString)
+// serilizePair 写入哈希部分的一项, 键不能是 table 或 nil, 值不能是 nil
+func serilizePair(buf byteWriter, k Value, v Value, depth int, canonical bool) error {
+	if isNil(k) || k.LuaType() == LUA_TABLE {
+		return fmt.Errorf("table key can't be table, array or nil")
+	}
+	if isNil(v) {
+		return fmt.Errorf("table value can't be nil")
+	}
+	err := serilizeValue(buf, k, depth+1, canonical)
+	if err != nil {
+		return err
+	}
+	return serilizeValue(buf, v, depth+1, canonical)
+}
+
+func serilizeOrderedTable(buf byteWriter, table *OrderedTable, depth int, canonical bool) error {
+	if table.err != nil {
+		return table.err
+	}
+	if canonical {
+		return serilizeTableWith(buf, table.Table(), depth, canonical)
+	}
+	if len(table.Array) > 0 {
+		if err := serilizeTableArray(buf, table.Array, depth+1, canonical); err != nil {
+			return err
+		}
+	} else {
+		buf.WriteByte(combineType(TYPE_TABLE, 0))
+	}
+	// Pairs 可以不经过 Set 直接填充, 与 Table 一样检查键和值
+	for _, p := range table.Pairs {
+		if err := serilizePair(buf, p.Key, p.Value, depth, canonical); err != nil {
+			return err
+		}
+	}
+	serilizeNil(buf) // end of table
+	return nil
+}
+
+// 长字符串的长度字段为 uint32, 更长的字符串无法表示
+const MAX_STRING_SIZE = math.MaxUint32
+
+func serilizeStringHeader(buf byteWriter, sz int) error {
 	if sz < MAX_COOKIE {
 		buf.WriteByte(combineType(TYPE_SHORT_STRING, uint8(sz)))
-		if sz > 0 {
-			buf.Write([]byte(luaString))
-		}
-		return
+		return nil
 	}
 	if sz < 0x10000 {
 		buf.WriteByte(combineType(TYPE_LONG_STRING, 2))
 		binary.Write(buf, binary.LittleEndian, uint16(sz))
-	} else {
-		buf.WriteByte(combineType(TYPE_LONG_STRING, 4))
-		binary.Write(buf, binary.LittleEndian, uint32(sz))
+		return nil
 	}
-	buf.Write([]byte(luaString))
+	if uint64(sz) > MAX_STRING_SIZE {
+		return fmt.Errorf("serialize string too long: %d bytes", sz)
+	}
+	buf.WriteByte(combineType(TYPE_LONG_STRING, 4))
+	binary.Write(buf, binary.LittleEndian, uint32(sz))
+	return nil
 }
 
-func serilizeInteger(buf *bytes.Buffer, v Integer) {
+func serilizeString(buf byteWriter, luaString String) error {
+	if err := serilizeStringHeader(buf, len(luaString)); err != nil {
+		return err
+	}
+	io.WriteString(buf, string(luaString))
+	return nil
+}
+
+func serilizeBytes(buf byteWriter, b Bytes) error {
+	if err := serilizeStringHeader(buf, len(b)); err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+func serilizeInteger(buf byteWriter, v Integer) {
 	if v == 0 {
 		buf.WriteByte(combineType(TYPE_NUMBER, TYPE_NUMBER_ZERO))
 		return
@@ -183,11 +346,231 @@ func serilizeInteger(buf *bytes.Buffer, v Integer) {
 	binary.Write(buf, binary.LittleEndian, uint32(v))
 }
 
-func deserilizeLuaValue(buf *bytes.Buffer) (Value, error) {
+type DecodeOptions struct {
+	MaxDepth        int // 最大嵌套层数
+	MaxArrayLength  int // Table 数组部分的最大长度
+	MaxStringLength int // 字符串的最大长度
+	MaxTotalSize    int // 读取的最大字节数, 流式 Decoder 对每个顶层对象分别计算
+
+	// 字符串直接引用输入的内存, 避免复制. 仅对 DeserializeWithOptions 有效,
+	// 调用方需要保证在使用反序列化结果期间不修改输入
+	ZeroCopy bool
+
+	// 作为值的字符串解码为 Bytes, Table 的键仍然是 String
+	Bytes bool
+}
+
+const (
+	DEFAULT_MAX_DEPTH         = 32
+	DEFAULT_MAX_ARRAY_LENGTH  = 1 << 24
+	DEFAULT_MAX_STRING_LENGTH = 1 << 30
+	DEFAULT_MAX_TOTAL_SIZE    = 1<<31 - 1
+)
+
+var ErrLimitExceeded = errors.New("deserialize limit exceeded")
+
+// 为零的字段使用默认值
+func (o DecodeOptions) withDefaults() DecodeOptions {
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = DEFAULT_MAX_DEPTH
+	}
+	if o.MaxArrayLength <= 0 {
+		o.MaxArrayLength = DEFAULT_MAX_ARRAY_LENGTH
+	}
+	if o.MaxStringLength <= 0 {
+		o.MaxStringLength = DEFAULT_MAX_STRING_LENGTH
+	}
+	if o.MaxTotalSize <= 0 {
+		o.MaxTotalSize = DEFAULT_MAX_TOTAL_SIZE
+	}
+	return o
+}
+
+// decodeState 记录已读取的字节数, 并在输入长度已知时拒绝超出剩余长度的分配.
+// 从 []byte 反序列化时直接在 data 上读取, 不经过 io.Reader
+type decodeState struct {
+	r      byteReader // nil when decoding from data
+	data   []byte
+	opts   DecodeOptions
+	offset int
+	size   int // -1 for unknown
+
+	scratch [8]byte
+}
+
+var decodeStatePool = sync.Pool{
+	New: func() any {
+		return &decodeState{}
+	},
+}
+
+func newDecodeState(r byteReader, size int, opts DecodeOptions) *decodeState {
+	return &decodeState{
+		r:    r,
+		opts: opts.withDefaults(),
+		size: size,
+	}
+}
+
+func (d *decodeState) limitError() error {
+	return fmt.Errorf("%w: total size exceeds %d", ErrLimitExceeded, d.opts.MaxTotalSize)
+}
+
+func (d *decodeState) Read(p []byte) (int, error) {
+	if d.offset+len(p) > d.opts.MaxTotalSize {
+		return 0, d.limitError()
+	}
+	if d.r == nil {
+		if d.offset >= len(d.data) {
+			return 0, io.EOF
+		}
+		n := copy(p, d.data[d.offset:])
+		d.offset += n
+		return n, nil
+	}
+	n, err := d.r.Read(p)
+	d.offset += n
+	return n, err
+}
+
+func (d *decodeState) ReadByte() (byte, error) {
+	if d.offset+1 > d.opts.MaxTotalSize {
+		return 0, d.limitError()
+	}
+	if d.r == nil {
+		if d.offset >= len(d.data) {
+			return 0, io.EOF
+		}
+		b := d.data[d.offset]
+		d.offset++
+		return b, nil
+	}
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.offset++
+	}
+	return b, err
+}
+
+func (d *decodeState) remaining() int {
+	if d.size < 0 {
+		return -1
+	}
+	return d.size - d.offset
+}
+
+func (d *decodeState) truncated(what string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("truncated %s at offset %d: %w", what, d.offset, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// readFixed 读取定长的数字, 返回的切片只在下一次读取之前有效
+func (d *decodeState) readFixed(n int, what string) ([]byte, error) {
+	if d.r == nil {
+		if d.offset+n > d.opts.MaxTotalSize {
+			return nil, d.limitError()
+		}
+		if n > len(d.data)-d.offset {
+			d.offset = len(d.data)
+			return nil, d.truncated(what, io.ErrUnexpectedEOF)
+		}
+		b := d.data[d.offset : d.offset+n]
+		d.offset += n
+		return b, nil
+	}
+	_, err := io.ReadFull(d, d.scratch[:n])
+	if err != nil {
+		return nil, d.truncated(what, err)
+	}
+	return d.scratch[:n], nil
+}
+
+// readBytes 从 data 读取时返回输入的子切片, 不做复制
+func (d *decodeState) readBytes(sz int, what string) ([]byte, error) {
+	if sz > d.opts.MaxStringLength {
+		return nil, fmt.Errorf("%w: %s length %d exceeds %d", ErrLimitExceeded, what, sz, d.opts.MaxStringLength)
+	}
+	if rem := d.remaining(); rem >= 0 && sz > rem {
+		return nil, fmt.Errorf("truncated %s at offset %d, need %d bytes, have %d: %w", what, d.offset, sz, rem, io.ErrUnexpectedEOF)
+	}
+	if d.r == nil {
+		return d.readFixed(sz, what)
+	}
+	if d.size < 0 {
+		// 输入长度未知时按实际读取的数据增长, 避免直接按声明的长度分配
+		b, err := io.ReadAll(io.LimitReader(d, int64(sz)))
+		if err == nil && len(b) < sz {
+			err = io.ErrUnexpectedEOF
+		}
+		return b, d.truncated(what, err)
+	}
+	b := make([]byte, sz)
+	_, err := io.ReadFull(d, b)
+	return b, d.truncated(what, err)
+}
+
+func (d *decodeState) readString(sz int, what string) (Value, error) {
+	b, err := d.readBytes(sz, what)
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.Bytes {
+		if d.r == nil && !d.opts.ZeroCopy {
+			b = append([]byte(nil), b...)
+		}
+		return Bytes(b), nil
+	}
+	if d.opts.ZeroCopy && d.r == nil && len(b) > 0 {
+		return String(unsafe.String(&b[0], len(b))), nil
+	}
+	return String(b), nil
+}
+
+func DeserializeWithOptions(data []byte, opts DecodeOptions) ([]Value, error) {
+	buf := decodeStatePool.Get().(*decodeState)
+	*buf = decodeState{
+		data: data,
+		opts: opts.withDefaults(),
+		size: len(data),
+	}
+	defer func() {
+		buf.data = nil
+		decodeStatePool.Put(buf)
+	}()
+	values := make([]Value, 0, 4)
+	for {
+		value, err := deserilizeLuaValue(buf, 0)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func deserilizeLuaValue(buf *decodeState, depth int) (Value, error) {
 	head, err := buf.ReadByte()
 	if err != nil {
 		return nil, err
 	}
+	return deserilizeValue(buf, head, depth)
+}
+
+// 读取对象内部的值, 此时遇到 EOF 说明数据被截断
+func deserilizeInnerValue(buf *decodeState, depth int, what string) (Value, error) {
+	v, err := deserilizeLuaValue(buf, depth)
+	if err == io.EOF {
+		return nil, buf.truncated(what, err)
+	}
+	return v, err
+}
+
+func deserilizeValue(buf *decodeState, head uint8, depth int) (Value, error) {
 	typ, cookie := head&0x07, head>>3
 	switch typ {
 	case TYPE_NIL:
@@ -205,121 +588,172 @@ func deserilizeLuaValue(buf *bytes.Buffer) (Value, error) {
 			return deserilizeInteger(buf, cookie)
 		}
 	case TYPE_USERDATA:
-		var pointer uint64 // skip
-		err := binary.Read(buf, binary.LittleEndian, &pointer)
-		return Nil{}, err
+		b, err := buf.readFixed(8, "userdata")
+		if err != nil {
+			return nil, err
+		}
+		return LightUserdata(binary.LittleEndian.Uint64(b)), nil
 	case TYPE_SHORT_STRING:
-		b := make([]byte, cookie)
-		_, err := buf.Read(b)
-		return String(string(b)), err
+		return buf.readString(int(cookie), "short string")
 	case TYPE_LONG_STRING:
 		return deserilizeLongString(buf, cookie)
 	case TYPE_TABLE:
-		return deserilizeTable(buf, cookie)
+		return deserilizeTable(buf, cookie, depth+1)
 	default:
 		return nil, fmt.Errorf("unsupported lua value type: %v", typ)
 	}
 }
 
-func deserilizeTable(buf *bytes.Buffer, arrLen uint8) (Value, error) {
+func deserilizeTableSize(buf *decodeState, arrLen uint8) (int, error) {
 	var arrSize Integer
 	if arrLen == MAX_COOKIE-1 {
-		var head uint8
-		err := binary.Read(buf, binary.LittleEndian, &head)
+		head, err := buf.ReadByte()
 		if err != nil {
-			return nil, err
+			return 0, buf.truncated("table size", err)
 		}
 		typ, cookie := head&0x07, head>>3
 		if typ != TYPE_NUMBER || cookie == TYPE_NUMBER_REAL {
-			return nil, fmt.Errorf("unsupported table cookie: %v", head)
+			return 0, fmt.Errorf("unsupported table cookie: %v", head)
 		}
 		v, err := deserilizeInteger(buf, cookie)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 		arrSize = v.(Integer)
 	} else {
 		arrSize = Integer(arrLen)
 	}
+	if arrSize < 0 {
+		return 0, fmt.Errorf("invalid table array size: %d", arrSize)
+	}
+	if arrSize > Integer(buf.opts.MaxArrayLength) {
+		return 0, fmt.Errorf("%w: table array size %d exceeds %d", ErrLimitExceeded, arrSize, buf.opts.MaxArrayLength)
+	}
+	// 每个数组元素至少占用一个字节
+	if rem := buf.remaining(); rem >= 0 && arrSize > Integer(rem) {
+		return 0, fmt.Errorf("truncated table array at offset %d, need %d values, have %d bytes: %w", buf.offset, arrSize, rem, io.ErrUnexpectedEOF)
+	}
+	return int(arrSize), nil
+}
+
+func deserilizeTable(buf *decodeState, arrLen uint8, depth int) (Value, error) {
+	if depth > buf.opts.MaxDepth {
+		return nil, fmt.Errorf("%w: table depth exceeds %d", ErrLimitExceeded, buf.opts.MaxDepth)
+	}
+	arrSize, err := deserilizeTableSize(buf, arrLen)
+	if err != nil {
+		return nil, err
+	}
+	capacity := arrSize
+	if buf.size < 0 {
+		capacity = min(arrSize, 1024)
+	}
+	// 纯数组的 Table 不分配 Hash
 	table := Table{
-		Array: make([]Value, arrSize),
-		Hash:  make(map[Value]Value),
+		Array: make([]Value, 0, capacity),
 	}
-	for i := 0; i < int(arrSize); i++ {
-		v, err := deserilizeLuaValue(buf)
+	for i := 0; i < arrSize; i++ {
+		v, err := deserilizeInnerValue(buf, depth, "table array")
 		if err != nil {
 			return nil, err
 		}
-		table.Array[i] = v
+		table.Array = append(table.Array, v)
 	}
 	for {
-		key, err := deserilizeLuaValue(buf)
+		key, err := deserilizeInnerValue(buf, depth, "table key")
 		if err != nil {
 			return nil, err
 		}
 		if key.LuaType() == LUA_NIL {
 			break
 		}
-		value, err := deserilizeLuaValue(buf)
+		if b, ok := key.(Bytes); ok {
+			key = String(b)
+		}
+		if err := checkTableKey(key); err != nil {
+			return nil, err
+		}
+		value, err := deserilizeInnerValue(buf, depth, "table value")
 		if err != nil {
 			return nil, err
 		}
+		if table.Hash == nil {
+			table.Hash = make(map[Value]Value)
+		}
 		table.Hash[key] = value
 
 	}
 	return table, nil
 }
 
-func deserilizeLongString(buf *bytes.Buffer, cookie uint8) (Value, error) {
+func checkTableKey(key Value) error {
+	switch k := key.(type) {
+	case Table:
+		return fmt.Errorf("table key can't be table")
+	case Bytes:
+		return fmt.Errorf("table key can't be bytes")
+	case Real:
+		if k != k {
+			return fmt.Errorf("table key can't be NaN")
+		}
+	}
+	return nil
+}
+
+func deserilizeLongString(buf *decodeState, cookie uint8) (Value, error) {
 	if cookie == 2 {
-		var sz uint16
-		err := binary.Read(buf, binary.LittleEndian, &sz)
+		b, err := buf.readFixed(2, "long string size")
 		if err != nil {
 			return nil, err
 		}
-		b := make([]byte, sz)
-		_, err = buf.Read(b)
-		return String(string(b)), err
+		return buf.readString(int(binary.LittleEndian.Uint16(b)), "long string")
 	}
 	if cookie == 4 {
-		var sz uint32
-		err := binary.Read(buf, binary.LittleEndian, &sz)
+		b, err := buf.readFixed(4, "long string size")
 		if err != nil {
 			return nil, err
 		}
-		b := make([]byte, sz)
-		_, err = buf.Read(b)
-		return String(string(b)), err
+		return buf.readString(int(binary.LittleEndian.Uint32(b)), "long string")
 	}
 	return nil, fmt.Errorf("unsupported long string cookie: %v", cookie)
 }
 
-func deserilizeReal(buf *bytes.Buffer) (Value, error) {
-	var v float64
-	err := binary.Read(buf, binary.LittleEndian, &v)
-	return Real(v), err
+func deserilizeReal(buf *decodeState) (Value, error) {
+	b, err := buf.readFixed(8, "real")
+	if err != nil {
+		return nil, err
+	}
+	return Real(math.Float64frombits(binary.LittleEndian.Uint64(b))), nil
 }
 
-func deserilizeInteger(buf *bytes.Buffer, cookie uint8) (Value, error) {
+func deserilizeInteger(buf *decodeState, cookie uint8) (Value, error) {
 	switch cookie {
 	case TYPE_NUMBER_ZERO:
 		return Integer(0), nil
 	case TYPE_NUMBER_BYTE:
-		var v uint8
-		err := binary.Read(buf, binary.LittleEndian, &v)
-		return Integer(v), err
+		b, err := buf.readFixed(1, "integer")
+		if err != nil {
+			return nil, err
+		}
+		return Integer(b[0]), nil
 	case TYPE_NUMBER_WORD:
-		var v uint16
-		err := binary.Read(buf, binary.LittleEndian, &v)
-		return Integer(v), err
+		b, err := buf.readFixed(2, "integer")
+		if err != nil {
+			return nil, err
+		}
+		return Integer(binary.LittleEndian.Uint16(b)), nil
 	case TYPE_NUMBER_DWORD:
-		var v int32
-		err := binary.Read(buf, binary.LittleEndian, &v)
-		return Integer(v), err
+		b, err := buf.readFixed(4, "integer")
+		if err != nil {
+			return nil, err
+		}
+		return Integer(int32(binary.LittleEndian.Uint32(b))), nil
 	case TYPE_NUMBER_QWORD:
-		var v int64
-		err := binary.Read(buf, binary.LittleEndian, &v)
-		return Integer(v), err
+		b, err := buf.readFixed(8, "integer")
+		if err != nil {
+			return nil, err
+		}
+		return Integer(int64(binary.LittleEndian.Uint64(b))), nil
 	default:
 		return nil, fmt.Errorf("unsupported integer cookie: %v", cookie)
 	}
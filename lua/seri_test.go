@@ -2,6 +2,7 @@ package lua
 
 import (
 	"bytes"
+	"math"
 	"testing"
 )
 
@@ -124,12 +125,7 @@ func TestSerilizeTableArray(t *testing.T) {
 	if err != nil {
 		t.Errorf("deserialize failed: %v", err)
 	}
-	unpackedTable := unpacked[0].(Table)
-	for i, v := range arr.Array {
-		if v != unpackedTable.Array[i] {
-			t.Errorf("value not match: %v != %v", v, unpackedTable.Array[i])
-		}
-	}
+	AssertEqual(t, arr, unpacked[0])
 }
 
 func TestSerilizeTableHash(t *testing.T) {
@@ -151,16 +147,7 @@ func TestSerilizeTableHash(t *testing.T) {
 	if err != nil {
 		t.Errorf("deserialize failed: %v", err)
 	}
-	unpackedTable := unpacked[0].(Table)
-	for k, v := range table.Hash {
-		unpackedTableValue, ok := unpackedTable.Hash[k]
-		if !ok {
-			t.Errorf("key not found: %v", k)
-		}
-		if v != unpackedTableValue {
-			t.Errorf("value not match: %v != %v", v, unpackedTableValue)
-		}
-	}
+	AssertEqual(t, table, unpacked[0])
 }
 
 func TestSerilizeMixedTable(t *testing.T) {
@@ -187,19 +174,147 @@ func TestSerilizeMixedTable(t *testing.T) {
 	if err != nil {
 		t.Errorf("deserialize failed: %v", err)
 	}
+	AssertEqual(t, tableMixed, unpacked[0])
+}
+
+func TestSerilizeLightUserdata(t *testing.T) {
+	table := Table{
+		Array: []Value{
+			Integer(1),
+			LightUserdata(0x7f0000001000),
+			Integer(3),
+		},
+		Hash: map[Value]Value{
+			LightUserdata(0x7f0000002000): String("handle"),
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	err := serilizeTable(buf, table, 0)
+	if err != nil {
+		t.Errorf("serilize table failed: %v", err)
+	}
+	unpacked, err := Deserialize(buf.Bytes())
+	if err != nil {
+		t.Errorf("deserialize failed: %v", err)
+	}
 	unpackedTable := unpacked[0].(Table)
-	for i, v := range tableMixed.Array {
-		if v != unpackedTable.Array[i] {
-			t.Errorf("value not match: %v != %v", v, unpackedTable.Array[i])
+	if unpackedTable.Array[1] != LightUserdata(0x7f0000001000) {
+		t.Errorf("value not match: %v", unpackedTable.Array[1])
+	}
+	if unpackedTable.Hash[LightUserdata(0x7f0000002000)] != String("handle") {
+		t.Errorf("key not found: %v", unpackedTable.Hash)
+	}
+}
+
+func TestSerializeCanonical(t *testing.T) {
+	build := func() Table {
+		hash := make(map[Value]Value)
+		for i := 0; i < 20; i++ {
+			hash[Integer(i)] = String("v")
+			hash[String(string(rune('a'+i)))] = Table{Hash: map[Value]Value{Boolean(i%2 == 0): Real(i)}}
 		}
+		return Table{Array: []Value{Integer(1)}, Hash: hash}
 	}
-	for k, v := range tableMixed.Hash {
-		unpackedTableValue, ok := unpackedTable.Hash[k]
-		if !ok {
-			t.Errorf("key not found: %v", k)
+	expected, err := SerializeCanonical([]Value{build()})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		packed, _ := SerializeCanonical([]Value{build()})
+		if !bytes.Equal(packed, expected) {
+			t.Fatalf("canonical serialization is not stable")
 		}
-		if v != unpackedTableValue {
-			t.Errorf("value not match: %v != %v", v, unpackedTableValue)
+	}
+	h1, _ := Hash(build())
+	h2, _ := Hash(build())
+	if h1 != h2 || !Equal(build(), build()) {
+		t.Errorf("hash or equal not match")
+	}
+	if Equal(Integer(1), Real(1)) {
+		t.Errorf("integer and real should not be equal")
+	}
+}
+
+func TestDeserializeZeroCopy(t *testing.T) {
+	packed, _ := Serialize([]Value{
+		String("hellohellohellohellohellohellohellohello"),
+		Table{Array: []Value{String("a"), String("b")}},
+	})
+	unpacked, err := DeserializeWithOptions(packed, DecodeOptions{ZeroCopy: true})
+	if err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	if unpacked[0] != String("hellohellohellohellohellohellohellohello") {
+		t.Errorf("value not match: %v", unpacked[0])
+	}
+	if unpacked[1].(Table).Hash != nil {
+		t.Errorf("array only table should not allocate hash")
+	}
+	// 字符串引用输入的内存
+	packed[3] = 'H'
+	if unpacked[0] != String("Hellohellohellohellohellohellohellohello") {
+		t.Errorf("string does not alias input: %v", unpacked[0])
+	}
+}
+
+func TestBytes(t *testing.T) {
+	payload := bytes.Repeat([]byte{0, 1, 2, 0xff}, 20000)
+	fromBytes, err := Serialize([]Value{Bytes(payload), Table{Hash: map[Value]Value{String("data"): Bytes("x")}}})
+	if err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+	fromString, _ := Serialize([]Value{String(payload), Table{Hash: map[Value]Value{String("data"): String("x")}}})
+	if !bytes.Equal(fromBytes, fromString) {
+		t.Errorf("bytes and string serialize differently")
+	}
+
+	unpacked, err := DeserializeWithOptions(fromBytes, DecodeOptions{Bytes: true})
+	if err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	if b, ok := unpacked[0].(Bytes); !ok || !bytes.Equal(b, payload) {
+		t.Errorf("expected bytes, got %T", unpacked[0])
+	}
+	if v, ok := unpacked[1].(Table).Get("data"); !ok || !Equal(v, String("x")) {
+		t.Errorf("bytes value with string key not found: %v", unpacked[1])
+	}
+	fromBytes[10] = 'X'
+	if unpacked[0].(Bytes)[5] == 'X' {
+		t.Errorf("bytes should not alias input without ZeroCopy")
+	}
+
+	// 32 位平台上 int 无法表示超长的字符串
+	if n := uint64(MAX_STRING_SIZE) + 1; n <= math.MaxInt {
+		if err := serilizeStringHeader(&bytes.Buffer{}, int(n)); err == nil {
+			t.Errorf("expected string too long error")
 		}
 	}
 }
+
+func TestSerializedSize(t *testing.T) {
+	values := []Value{
+		String("hello"),
+		String(bytes.Repeat([]byte("x"), 70000)),
+		Table{Array: []Value{Integer(1), Real(2)}, Hash: map[Value]Value{String("a"): Boolean(true)}},
+		LightUserdata(1),
+	}
+	packed, _ := Serialize(values)
+	if n := SerializedSize(values); n != len(packed) {
+		t.Errorf("size not match: %d != %d", n, len(packed))
+	}
+	prefix := []byte("head")
+	appended, err := AppendSerialize(prefix, values)
+	if err != nil {
+		t.Fatalf("append serialize failed: %v", err)
+	}
+	if !bytes.Equal(appended[:4], prefix) || !bytes.Equal(appended[4:], packed) {
+		t.Errorf("append serialize not match")
+	}
+	deep := Table{}
+	for i := 0; i < 40; i++ {
+		deep = Table{Array: []Value{deep}}
+	}
+	if n := SerializedSize([]Value{deep}); n != -1 {
+		t.Errorf("expected -1 for invalid value, got %d", n)
+	}
+}
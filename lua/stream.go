@@ -0,0 +1,283 @@
+package lua
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 基于 io.Writer/io.Reader 的流式序列化与反序列化
+// 1. Encode/Decode 处理完整的 Lua 对象
+// 2. BeginTable/Key/EndTable 与 Token 用于逐项生成或消费大型 Table
+
+var ErrEndOfTable = errors.New("end of table")
+
+// 表示正在编码/解码的 Table, arrayLeft 为数组部分剩余的元素个数
+type streamFrame struct {
+	arrayLeft   int
+	expectValue bool
+}
+
+func (f *streamFrame) atKey() bool {
+	return f.arrayLeft == 0 && !f.expectValue
+}
+
+func (f *streamFrame) next() {
+	if f.arrayLeft > 0 {
+		f.arrayLeft--
+	} else {
+		f.expectValue = !f.expectValue
+	}
+}
+
+type Encoder struct {
+	w       *bufio.Writer
+	frames  []streamFrame
+	scratch appendWriter // 对象先完整序列化到这里, 出错时不会写出部分内容
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w: bufio.NewWriter(w),
+	}
+}
+
+func (e *Encoder) top() *streamFrame {
+	if len(e.frames) == 0 {
+		return nil
+	}
+	return &e.frames[len(e.frames)-1]
+}
+
+func (e *Encoder) write(v Value) error {
+	e.scratch.buf = e.scratch.buf[:0]
+	if err := serilizeLuaValue(&e.scratch, v, len(e.frames)); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.scratch.buf)
+	return err
+}
+
+func (e *Encoder) checkValue(v Value) error {
+	f := e.top()
+	if f == nil {
+		return nil
+	}
+	if f.atKey() {
+		return fmt.Errorf("encoder expects a table key")
+	}
+	if v.LuaType() == LUA_NIL {
+		if f.arrayLeft > 0 {
+			return fmt.Errorf("array value can't be nil")
+		}
+		return fmt.Errorf("table value can't be nil")
+	}
+	return nil
+}
+
+func (e *Encoder) valueDone() error {
+	if f := e.top(); f != nil {
+		f.next()
+		return nil
+	}
+	return e.w.Flush()
+}
+
+// Encode 写入一个完整的 Lua 对象，在 Table 内部时作为数组元素或键对应的值
+func (e *Encoder) Encode(v Value) error {
+	if v == nil {
+		v = Nil{}
+	}
+	if err := e.checkValue(v); err != nil {
+		return err
+	}
+	if err := e.write(v); err != nil {
+		return err
+	}
+	return e.valueDone()
+}
+
+// BeginTable 开始一个 Table, 之后的 arrayLen 次 Encode 写入数组部分
+func (e *Encoder) BeginTable(arrayLen int) error {
+	if arrayLen < 0 {
+		return fmt.Errorf("invalid array length: %d", arrayLen)
+	}
+	if err := e.checkValue(Table{}); err != nil {
+		return err
+	}
+	if len(e.frames) >= 32 {
+		return fmt.Errorf("serialize can't pack too deep")
+	}
+	serilizeTableHeader(e.w, arrayLen)
+	e.frames = append(e.frames, streamFrame{arrayLeft: arrayLen})
+	return nil
+}
+
+// Key 写入 Table 哈希部分的键，之后的一次 Encode 或 BeginTable 写入对应的值
+func (e *Encoder) Key(k Value) error {
+	f := e.top()
+	if f == nil {
+		return fmt.Errorf("encoder is not in a table")
+	}
+	if !f.atKey() {
+		return fmt.Errorf("encoder expects a table value")
+	}
+	if k == nil || k.LuaType() == LUA_TABLE || k.LuaType() == LUA_NIL {
+		return fmt.Errorf("table key can't be table, array or nil")
+	}
+	if err := e.write(k); err != nil {
+		return err
+	}
+	f.next()
+	return nil
+}
+
+func (e *Encoder) EndTable() error {
+	f := e.top()
+	if f == nil {
+		return fmt.Errorf("encoder is not in a table")
+	}
+	if f.arrayLeft > 0 {
+		return fmt.Errorf("table array is missing %d values", f.arrayLeft)
+	}
+	if f.expectValue {
+		return fmt.Errorf("table key is missing its value")
+	}
+	serilizeNil(e.w)
+	e.frames = e.frames[:len(e.frames)-1]
+	return e.valueDone()
+}
+
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+type TokenKind uint8
+
+const (
+	TokenValue TokenKind = iota
+	TokenBeginTable
+	TokenEndTable
+)
+
+type Token struct {
+	Kind     TokenKind
+	Value    Value // for TokenValue
+	ArrayLen int   // for TokenBeginTable
+}
+
+type Decoder struct {
+	r      *decodeState
+	frames []streamFrame
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r, DecodeOptions{})
+}
+
+func NewDecoderWithOptions(r io.Reader, opts DecodeOptions) *Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{
+		r: newDecodeState(br, -1, opts),
+	}
+}
+
+func (d *Decoder) top() *streamFrame {
+	if len(d.frames) == 0 {
+		return nil
+	}
+	return &d.frames[len(d.frames)-1]
+}
+
+func (d *Decoder) readHead() (uint8, error) {
+	// MaxTotalSize 限制的是单个顶层对象, 而不是整个流
+	if len(d.frames) == 0 {
+		d.r.offset = 0
+	}
+	head, err := d.r.ReadByte()
+	if err == io.EOF && len(d.frames) > 0 {
+		return 0, d.r.truncated("table", err)
+	}
+	return head, err
+}
+
+func (d *Decoder) valueDone() {
+	if f := d.top(); f != nil {
+		f.next()
+	}
+}
+
+// 在 Table 键的位置读到 nil 时表示 Table 结束
+func (d *Decoder) endTable(head uint8) bool {
+	f := d.top()
+	if f == nil || !f.atKey() || head != TYPE_NIL {
+		return false
+	}
+	d.frames = d.frames[:len(d.frames)-1]
+	d.valueDone()
+	return true
+}
+
+// Decode 读取一个完整的 Lua 对象, 所有对象读取完毕时返回 io.EOF
+// 在 Token 打开的 Table 中读到结束标记时返回 ErrEndOfTable
+func (d *Decoder) Decode() (Value, error) {
+	head, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+	if d.endTable(head) {
+		return nil, ErrEndOfTable
+	}
+	v, err := deserilizeValue(d.r, head, len(d.frames))
+	if err != nil {
+		return nil, err
+	}
+	if f := d.top(); f != nil && f.atKey() {
+		if err := checkTableKey(v); err != nil {
+			return nil, err
+		}
+	}
+	d.valueDone()
+	return v, nil
+}
+
+// Token 读取下一个标记, Table 不会被完整读取而是以 TokenBeginTable 开始, TokenEndTable 结束
+func (d *Decoder) Token() (Token, error) {
+	head, err := d.readHead()
+	if err != nil {
+		return Token{}, err
+	}
+	if d.endTable(head) {
+		return Token{Kind: TokenEndTable}, nil
+	}
+	typ, cookie := head&0x07, head>>3
+	if typ == TYPE_TABLE {
+		if f := d.top(); f != nil && f.atKey() {
+			return Token{}, fmt.Errorf("table key can't be table")
+		}
+		if len(d.frames) >= d.r.opts.MaxDepth {
+			return Token{}, fmt.Errorf("%w: table depth exceeds %d", ErrLimitExceeded, d.r.opts.MaxDepth)
+		}
+		arrSize, err := deserilizeTableSize(d.r, cookie)
+		if err != nil {
+			return Token{}, err
+		}
+		d.frames = append(d.frames, streamFrame{arrayLeft: arrSize})
+		return Token{Kind: TokenBeginTable, ArrayLen: arrSize}, nil
+	}
+	v, err := deserilizeValue(d.r, head, len(d.frames))
+	if err != nil {
+		return Token{}, err
+	}
+	if f := d.top(); f != nil && f.atKey() {
+		if err := checkTableKey(v); err != nil {
+			return Token{}, err
+		}
+	}
+	d.valueDone()
+	return Token{Kind: TokenValue, Value: v}, nil
+}
@@ -0,0 +1,178 @@
+package lua
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestEncoderTokens(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	steps := []func() error{
+		func() error { return enc.Encode(String("request")) },
+		func() error { return enc.BeginTable(2) },
+		func() error { return enc.Encode(Integer(1)) },
+		func() error { return enc.Encode(String("two")) },
+		func() error { return enc.Key(String("body")) },
+		func() error { return enc.Encode(String("hello world")) },
+		func() error { return enc.Key(String("headers")) },
+		func() error { return enc.BeginTable(0) },
+		func() error { return enc.Key(String("X")) },
+		func() error { return enc.Encode(String("Y")) },
+		func() error { return enc.EndTable() },
+		func() error { return enc.EndTable() },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+	expected, _ := Serialize([]Value{
+		String("request"),
+		Table{
+			Array: []Value{Integer(1), String("two")},
+			Hash: map[Value]Value{
+				String("body"): String("hello world"),
+				String("headers"): Table{
+					Hash: map[Value]Value{String("X"): String("Y")},
+				},
+			},
+		},
+	})
+	values, err := Deserialize(buf.Bytes())
+	if err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	if len(values) != 2 || buf.Len() != len(expected) {
+		t.Fatalf("unexpected encoded values: %v", values)
+	}
+	hash := values[1].(Table).Hash
+	if hash[String("headers")].(Table).Hash[String("X")] != String("Y") {
+		t.Errorf("value not match: %v", hash)
+	}
+}
+
+func TestEncoderInvalidOrder(t *testing.T) {
+	enc := NewEncoder(io.Discard)
+	if err := enc.BeginTable(0); err != nil {
+		t.Fatalf("begin table failed: %v", err)
+	}
+	if err := enc.Encode(Integer(1)); err == nil {
+		t.Errorf("value without key should fail")
+	}
+	if err := enc.Key(String("k")); err != nil {
+		t.Fatalf("key failed: %v", err)
+	}
+	if err := enc.EndTable(); err == nil {
+		t.Errorf("end table without value should fail")
+	}
+}
+
+func TestDecoderTokens(t *testing.T) {
+	packed, _ := Serialize([]Value{
+		Integer(7),
+		Table{
+			Array: []Value{String("a")},
+			Hash:  map[Value]Value{String("k"): Table{Array: []Value{Boolean(true)}}},
+		},
+	})
+	dec := NewDecoder(bytes.NewReader(packed))
+	v, err := dec.Decode()
+	if err != nil || v != Integer(7) {
+		t.Fatalf("decode failed: %v %v", v, err)
+	}
+	kinds := []TokenKind{TokenBeginTable, TokenValue, TokenValue, TokenBeginTable, TokenValue, TokenEndTable, TokenEndTable}
+	for i, kind := range kinds {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("token %d failed: %v", i, err)
+		}
+		if tok.Kind != kind {
+			t.Fatalf("token %d kind not match: %v != %v", i, tok.Kind, kind)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	packed, _ := Serialize([]Value{Table{Array: []Value{String("hello")}}})
+	dec := NewDecoder(bytes.NewReader(packed[:len(packed)-1]))
+	if _, err := dec.Token(); err != nil {
+		t.Fatalf("token failed: %v", err)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestEncoderPartialFailure(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	if err := enc.Encode(Table{Array: []Value{Integer(1), Nil{}}}); err == nil {
+		t.Fatalf("nil array value should fail")
+	}
+	// 失败的对象不留下部分内容
+	if err := enc.Encode(Integer(2)); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	values, err := Deserialize(buf.Bytes())
+	if err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	AssertEqual(t, Table{Array: []Value{Integer(2)}}, Table{Array: values})
+}
+
+func TestDecoderInvalidKey(t *testing.T) {
+	for _, key := range []Value{Table{}, Real(math.NaN())} {
+		w := appendWriter{}
+		serilizeTableHeader(&w, 0)
+		packed, _ := AppendSerialize(w.buf, []Value{key, String("v"), Nil{}})
+		dec := NewDecoder(bytes.NewReader(packed))
+		if _, err := dec.Token(); err != nil {
+			t.Fatalf("token failed: %v", err)
+		}
+		if v, err := dec.Decode(); err == nil {
+			t.Errorf("expected invalid key error, got %v", v)
+		}
+	}
+}
+
+func TestDecoderTotalSizePerValue(t *testing.T) {
+	values := []Value{String("0123456789"), String("abcdefghij"), String("klmnopqrst")}
+	packed, _ := Serialize(values)
+	opts := DecodeOptions{MaxTotalSize: 16}
+	// 每个对象都在限制之内, 总长度超过限制
+	dec := NewDecoderWithOptions(bytes.NewReader(packed), opts)
+	for i, want := range values {
+		v, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("decode %d failed: %v", i, err)
+		}
+		AssertEqual(t, want, v)
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	large, _ := Serialize([]Value{Table{Array: values}})
+	dec = NewDecoderWithOptions(bytes.NewReader(large), opts)
+	if _, err := dec.Token(); err != nil {
+		t.Fatalf("token failed: %v", err)
+	}
+	for {
+		if _, err := dec.Token(); err != nil {
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("expected limit error, got %v", err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package lua
+
+// Table 的数组部分与哈希部分可能同时包含整数键, 例如 {1, 2, [4] = 4} 或者 t[#t+1] 构造的 Table,
+// 以下方法按 Lua 的 ipairs/pairs 语义访问 Table
+
+func isNil(v Value) bool {
+	if t, ok := v.(*OrderedTable); ok {
+		return t == nil
+	}
+	return v == nil || v.LuaType() == LUA_NIL
+}
+
+// Normalize 返回新的 Table: 从 1 开始的连续整数键位于 Array 中, 其余键位于 Hash 中,
+// 值为整数的浮点数键转换为整数键, 值为 nil 的项被移除. 只处理最外层的 Table
+func Normalize(t Table) Table {
+	hash := make(map[Value]Value, len(t.Hash))
+	for k, v := range t.Hash {
+		if isNil(v) {
+			continue
+		}
+		// 同时存在 1 与 1.0 时以整数键为准
+		nk := normalizeKey(k)
+		if _, ok := hash[nk]; ok && nk != k {
+			continue
+		}
+		hash[nk] = v
+	}
+	array := make([]Value, 0, len(t.Array))
+	for i, v := range t.Array {
+		if isNil(v) {
+			// 空洞之后的元素移到哈希部分
+			for j := i + 1; j < len(t.Array); j++ {
+				if !isNil(t.Array[j]) {
+					hash[Integer(j+1)] = t.Array[j]
+				}
+			}
+			break
+		}
+		array = append(array, v)
+		delete(hash, Integer(i+1))
+	}
+	for {
+		v, ok := hash[Integer(len(array)+1)]
+		if !ok {
+			break
+		}
+		delete(hash, Integer(len(array)+1))
+		array = append(array, v)
+	}
+	return Table{
+		Array: array,
+		Hash:  hash,
+	}
+}
+
+// Sequence 返回 ipairs 遍历得到的值, 当 Table 中没有其他键时 ok 为 true
+func (t Table) Sequence() ([]Value, bool) {
+	n := t.Len()
+	seq := make([]Value, 0, n)
+	t.IPairs(func(_ int, v Value) bool {
+		seq = append(seq, v)
+		return true
+	})
+	count := 0
+	t.Pairs(func(_, _ Value) bool {
+		count++
+		return true
+	})
+	return seq, count == n
+}
+
+// IPairs 与 Lua 的 ipairs 相同, 从 1 开始遍历直到遇到 nil, fn 返回 false 时停止
+func (t Table) IPairs(fn func(i int, v Value) bool) {
+	for i := 1; ; i++ {
+		v, ok := t.Get(Integer(i))
+		if !ok || !fn(i, v) {
+			return
+		}
+	}
+}
+
+// Pairs 与 Lua 的 pairs 相同, 先遍历数组部分再遍历哈希部分, 跳过值为 nil 的项,
+// 数组部分已包含的整数键不会重复出现, 值为整数的浮点数键以整数键返回. fn 返回 false 时停止
+func (t Table) Pairs(fn func(k, v Value) bool) {
+	for i, v := range t.Array {
+		if isNil(v) {
+			continue
+		}
+		if !fn(Integer(i+1), v) {
+			return
+		}
+	}
+	for k, v := range t.Hash {
+		if isNil(v) {
+			continue
+		}
+		// 值为整数的浮点数键按整数键处理, 同时存在 1 与 1.0 时以整数键为准
+		nk := normalizeKey(k)
+		if nk != k && !isNil(t.Hash[nk]) {
+			continue
+		}
+		// 数组部分的空洞由哈希部分的同名键补上
+		if i, ok := nk.(Integer); ok && i >= 1 && int64(i) <= int64(len(t.Array)) && !isNil(t.Array[i-1]) {
+			continue
+		}
+		if !fn(nk, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 声明式的参数检查, 例如:
+//
+//	var args = lua.Schema{
+//		lua.Str("url"),
+//		lua.TableOf("opts",
+//			lua.Str("method").Default("GET").OneOf("GET", "POST"),
+//			lua.MapOf("headers", lua.Str("name"), lua.Str("value")).Optional(),
+//		).Optional(),
+//	}
+//
+// Validate 检查所有参数并填充默认值, 所有不符合的项在一个 *ValidationError 中返回
+
+type Rule struct {
+	name     string
+	expected string
+	match    func(Value) bool
+	optional bool
+	def      Value
+	oneOf    []Value
+	fields   []*Rule // TableOf
+	key      *Rule   // MapOf
+	elem     *Rule   // ArrayOf, MapOf
+	err      error
+}
+
+func newRule(name string, expected string, match func(Value) bool) *Rule {
+	return &Rule{name: name, expected: expected, match: match}
+}
+
+func Str(name string) *Rule {
+	return newRule(name, "string", func(v Value) bool {
+		return v.LuaType() == LUA_STRING
+	})
+}
+
+func Int(name string) *Rule {
+	return newRule(name, "integer", func(v Value) bool {
+		_, ok := toInteger(v)
+		return ok
+	})
+}
+
+func Num(name string) *Rule {
+	return newRule(name, "number", func(v Value) bool {
+		t := v.LuaType()
+		return t == LUA_INTEGER || t == LUA_REAL
+	})
+}
+
+func Bool(name string) *Rule {
+	return newRule(name, "boolean", func(v Value) bool {
+		_, ok := v.(Boolean)
+		return ok
+	})
+}
+
+// Any 接受任意非 nil 的值
+func Any(name string) *Rule {
+	return newRule(name, "value", func(v Value) bool {
+		return true
+	})
+}
+
+func isTable(v Value) bool {
+	return v.LuaType() == LUA_TABLE
+}
+
+// TableOf 按字段检查 Table, 未声明的字段不做检查
+func TableOf(name string, fields ...*Rule) *Rule {
+	r := newRule(name, "table", isTable)
+	r.fields = fields
+	return r
+}
+
+// ArrayOf 检查 Table 是序列并且每个元素符合 elem
+func ArrayOf(name string, elem *Rule) *Rule {
+	r := newRule(name, "array", isTable)
+	r.elem = elem
+	return r
+}
+
+// MapOf 检查 Table 的每个键符合 key, 每个值符合 value
+func MapOf(name string, key *Rule, value *Rule) *Rule {
+	r := newRule(name, "table", isTable)
+	r.key = key
+	r.elem = value
+	return r
+}
+
+func (r *Rule) Optional() *Rule {
+	r.optional = true
+	return r
+}
+
+// Default 设置缺省时填充的值, 隐含 Optional
+func (r *Rule) Default(v any) *Rule {
+	def, err := toValue(v)
+	if err != nil {
+		r.err = fmt.Errorf("invalid default for %s: %w", r.name, err)
+	}
+	r.def = def
+	r.optional = true
+	return r
+}
+
+func (r *Rule) OneOf(values ...any) *Rule {
+	for _, v := range values {
+		lv, err := toValue(v)
+		if err != nil {
+			r.err = fmt.Errorf("invalid option for %s: %w", r.name, err)
+			continue
+		}
+		r.oneOf = append(r.oneOf, lv)
+	}
+	return r
+}
+
+type ValidationError struct {
+	Errors []*PathError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "invalid arguments: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(path string, format string, args ...any) {
+	e.Errors = append(e.Errors, &PathError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+type Schema []*Rule
+
+// Validate 返回填充了默认值的参数, args 本身不会被修改
+func (s Schema) Validate(args []Value) ([]Value, error) {
+	for _, r := range s {
+		if err := r.check(); err != nil {
+			return nil, err
+		}
+	}
+	verr := &ValidationError{}
+	result := make([]Value, max(len(args), len(s)))
+	copy(result, args)
+	// 缺少的参数与 Lua 一样为 nil
+	for i, v := range result {
+		if v == nil {
+			result[i] = Nil{}
+		}
+	}
+	for i, r := range s {
+		path := r.name
+		if path == "" {
+			path = "#" + strconv.Itoa(i+1)
+		}
+		v, _ := r.validate(result[i], path, verr)
+		result[i] = v
+	}
+	if len(verr.Errors) > 0 {
+		return nil, verr
+	}
+	return result, nil
+}
+
+func (r *Rule) check() error {
+	if r.err != nil {
+		return r.err
+	}
+	subs := append([]*Rule{r.key, r.elem}, r.fields...)
+	for _, sub := range subs {
+		if sub == nil {
+			continue
+		}
+		if err := sub.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate 检查 v, 返回填充默认值后的值; changed 表示返回值与 v 不同
+func (r *Rule) validate(v Value, path string, verr *ValidationError) (Value, bool) {
+	if isNil(v) {
+		if r.def != nil {
+			// 默认值本身也要检查, 以填充其中嵌套的默认值
+			def, _ := r.validate(r.def, path, verr)
+			return def, true
+		}
+		if !r.optional {
+			verr.add(path, "expected %s, got nil", r.expected)
+		}
+		return v, false
+	}
+	if !r.match(v) {
+		verr.add(path, "expected %s, got %s", r.expected, typeName(v))
+		return v, false
+	}
+	if len(r.oneOf) > 0 {
+		found := false
+		for _, o := range r.oneOf {
+			if Equal(o, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(r.oneOf))
+			for i, o := range r.oneOf {
+				options[i] = Format(o)
+			}
+			verr.add(path, "expected one of %s, got %s", strings.Join(options, ", "), Format(v))
+			return v, false
+		}
+	}
+	if !isTable(v) {
+		return v, false
+	}
+	t, _ := asTable(v)
+	var nv Value
+	changed := false
+	switch {
+	case r.fields != nil:
+		nv, changed = r.validateFields(t, path, verr)
+	case r.key != nil:
+		nv, changed = r.validateMap(t, path, verr)
+	case r.elem != nil:
+		nv, changed = r.validateArray(t, path, verr)
+	}
+	if !changed {
+		return v, false
+	}
+	return nv, true
+}
+
+// setField 在 t 的副本中设置字段, 不修改调用者的 Table
+func setField(t Table, copied *bool, key Value, v Value) Table {
+	if !*copied {
+		hash := make(map[Value]Value, len(t.Hash)+1)
+		for k, hv := range t.Hash {
+			hash[k] = hv
+		}
+		t = Table{Array: append([]Value(nil), t.Array...), Hash: hash}
+		*copied = true
+	}
+	if i, ok := key.(Integer); ok && i >= 1 && int(i) <= len(t.Array) {
+		t.Array[i-1] = v
+		return t
+	}
+	t.Hash[key] = v
+	return t
+}
+
+func (r *Rule) validateFields(t Table, path string, verr *ValidationError) (Value, bool) {
+	copied := false
+	for _, f := range r.fields {
+		fv, _ := t.Get(f.name)
+		nv, changed := f.validate(fv, joinField(path, f.name), verr)
+		if changed {
+			t = setField(t, &copied, String(f.name), nv)
+		}
+	}
+	return t, copied
+}
+
+func (r *Rule) validateMap(t Table, path string, verr *ValidationError) (Value, bool) {
+	copied := false
+	check := func(k Value, v Value) {
+		kpath := joinKey(path, k)
+		r.key.validate(k, kpath, verr)
+		if nv, changed := r.elem.validate(v, kpath, verr); changed {
+			t = setField(t, &copied, k, nv)
+		}
+	}
+	t.IPairs(func(i int, v Value) bool {
+		check(Integer(i), v)
+		return true
+	})
+	hash := Normalize(t).Hash
+	for _, k := range sortedKeys(hash) {
+		check(k, hash[k])
+	}
+	return t, copied
+}
+
+func (r *Rule) validateArray(t Table, path string, verr *ValidationError) (Value, bool) {
+	seq, ok := t.Sequence()
+	if !ok {
+		verr.add(path, "expected array, got table")
+		return t, false
+	}
+	copied := false
+	for i, v := range seq {
+		if nv, changed := r.elem.validate(v, joinKey(path, Integer(i+1)), verr); changed {
+			t = setField(t, &copied, Integer(i+1), nv)
+		}
+	}
+	return t, copied
+}
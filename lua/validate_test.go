@@ -0,0 +1,84 @@
+package lua
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema := Schema{
+		Str("cmd").OneOf("request"),
+		Str("url"),
+		TableOf("opts",
+			Str("method").Default("GET").OneOf("GET", "POST"),
+			MapOf("headers", Str("name"), Str("value")).Optional(),
+			Bool("noHeader").Default(true),
+			ArrayOf("ports", Int("port")).Default([]int{80}),
+		).Default(Table{}),
+	}
+
+	opts := Table{Hash: map[Value]Value{String("method"): String("POST")}}
+	args, err := schema.Validate([]Value{String("request"), String("http://a"), opts})
+	if err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	filled := args[2].(Table)
+	if filled.StringOr("method", "") != "POST" || !filled.BoolOr("noHeader", false) {
+		t.Errorf("defaults not filled: %v", filled)
+	}
+	if ports, _ := filled.GetTable("ports"); ports.Len() != 1 {
+		t.Errorf("nested default not filled: %v", filled)
+	}
+	if _, ok := opts.Get("noHeader"); ok {
+		t.Errorf("caller table modified: %v", opts)
+	}
+
+	args, err = schema.Validate([]Value{String("request"), String("http://a")})
+	if err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if filled := args[2].(Table); filled.StringOr("method", "") != "GET" {
+		t.Errorf("default table not filled: %v", filled)
+	}
+
+	bad := Table{Hash: map[Value]Value{
+		String("method"):  String("PUT"),
+		String("headers"): Table{Hash: map[Value]Value{String("A"): Integer(1)}},
+		String("ports"):   Table{Array: []Value{Integer(1), Real(1.5)}},
+	}}
+	_, err = schema.Validate([]Value{String("get"), nil, bad})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	expected := []string{
+		`cmd: expected one of "request", got "get"`,
+		`url: expected string, got nil`,
+		`opts.method: expected one of "GET", "POST", got "PUT"`,
+		`opts.headers["A"]: expected string, got integer`,
+		`opts.ports[2]: expected integer, got real`,
+	}
+	if len(verr.Errors) != len(expected) {
+		t.Fatalf("errors not match: %v", err)
+	}
+	for i, e := range verr.Errors {
+		if e.Error() != expected[i] {
+			t.Errorf("error not match:\n%s\n%s", e.Error(), expected[i])
+		}
+	}
+
+	args, err = (Schema{Str("a"), Int("b").Optional(), Bool("c").Optional()}).Validate([]Value{String("x"), nil})
+	if err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if len(args) != 3 || args[1] != (Nil{}) || args[2] != (Nil{}) {
+		t.Errorf("missing optional args should be nil: %#v", args)
+	}
+	if _, err := Serialize(args); err != nil {
+		t.Errorf("serialize validated args failed: %v", err)
+	}
+
+	if _, err := (Schema{Str("a").Default(make(chan int))}).Validate(nil); err == nil {
+		t.Errorf("expected invalid default error")
+	}
+}
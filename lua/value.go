@@ -34,6 +34,25 @@ func (v String) LuaType() uint8 {
 	return LUA_STRING
 }
 
+// Bytes 是以 []byte 保存的 Lua 字符串, 序列化结果与 String 相同,
+// 用于传递二进制数据时避免与 string 之间的复制. Bytes 不可比较, 不能作为 Table 的键
+type Bytes []byte
+
+func (v Bytes) LuaType() uint8 {
+	return LUA_STRING
+}
+
+// stringOf 返回 String 或 Bytes 的内容
+func stringOf(v Value) (string, bool) {
+	switch s := v.(type) {
+	case String:
+		return string(s), true
+	case Bytes:
+		return string(s), true
+	}
+	return "", false
+}
+
 type Table struct {
 	Array []Value
 	Hash  map[Value]Value
@@ -42,3 +61,10 @@ type Table struct {
 func (v Table) LuaType() uint8 {
 	return LUA_TABLE
 }
+
+// 轻量用户数据, 仅作为不透明的句柄保存和传递
+type LightUserdata uint64
+
+func (v LightUserdata) LuaType() uint8 {
+	return LUA_LIGHTUSERDATA
+}
@@ -18,21 +18,13 @@ func (s *ExampleService) Execute(args []lua.Value) ([]lua.Value, error) {
 	return []lua.Value{
 		lua.Boolean(true),
 		lua.String("hello world"),
-		lua.Table{
-			Array: []lua.Value{
-				lua.Integer(1),
-				lua.Real(3.14),
-				lua.String("hello"),
-				lua.Boolean(true),
-			},
-			Hash: map[lua.Value]lua.Value{
-				lua.Boolean(true):    lua.String("true"),
-				lua.Boolean(false):   lua.String("false"),
-				lua.Integer(100):     lua.String("hello world"),
-				lua.String("number"): lua.Integer(200),
-				lua.String("string"): lua.Boolean(true),
-				lua.String("msg"):    lua.String("hello world"),
-			},
-		},
+		lua.NewTable().
+			Append(lua.Integer(1), lua.Real(3.14), lua.String("hello"), lua.Boolean(true)).
+			Set(lua.Boolean(true), lua.String("true")).
+			Set(lua.Boolean(false), lua.String("false")).
+			Set(lua.Integer(100), lua.String("hello world")).
+			Set("number", lua.Integer(200)).
+			Set("string", lua.Boolean(true)).
+			Set("msg", lua.String("hello world")),
 	}, nil
 }
@@ -38,60 +38,46 @@ func NewHttpService() Service {
 	}
 }
 
-func checkMethod(method string) bool {
-	switch method {
-	case "GET", "POST", "PUT", "DELETE", "PATCH":
-		return true
-	default:
-		return false
-	}
+var httpArgs = lua.Schema{
+	lua.Str("cmd").OneOf("request"),
+	lua.Str("url"),
+	lua.TableOf("opts",
+		lua.Str("method").Default("GET").OneOf("GET", "POST", "PUT", "DELETE", "PATCH"),
+		lua.MapOf("headers", lua.Str("name"), lua.Str("value")).Optional(),
+		lua.Str("body").Default(""),
+		lua.Bool("noBody").Default(false),
+		lua.Bool("noHeader").Default(true),
+	),
 }
 
 func parseArgs(args []lua.Value) (request, error) {
 	var req request
-	// 参数检查和处理
-	if len(args) < 3 {
-		return req, fmt.Errorf("args length error")
-	}
-	cmd, ok := args[0].(lua.String)
-	if !ok || cmd != "request" {
-		return req, fmt.Errorf("cmd parse error")
-	}
-
-	url, ok := args[1].(lua.String)
-	if !ok {
-		return req, fmt.Errorf("url parse error")
-	}
-
-	opts, ok := args[2].(lua.Table)
-	if !ok {
-		return req, fmt.Errorf("opts parse error")
-	}
-
-	method := opts.Hash[lua.String("method")].(lua.String)
-	headers := opts.Hash[lua.String("headers")].(lua.Table)
-	body := opts.Hash[lua.String("body")].(lua.String)
-	noBody := opts.Hash[lua.String("noBody")].(lua.Boolean)
-	noHeader := opts.Hash[lua.String("noHeader")].(lua.Boolean)
-
-	if !checkMethod(string(method)) {
-		return req, fmt.Errorf("method error")
+	args, err := httpArgs.Validate(args)
+	if err != nil {
+		return req, err
 	}
 
+	// 除 headers 外的字段都有默认值, 校验后一定存在
+	opts := args[2].(lua.Table)
+	method, _ := opts.GetString("method")
+	body, _ := opts.GetString("body")
+	noBody, _ := opts.GetBool("noBody")
+	noHeader, _ := opts.GetBool("noHeader")
+	headers, _ := opts.GetTable("headers")
 	reqHeaders := make(map[string]string)
-	for k, v := range headers.Hash {
-		reqHeaders[string(k.(lua.String))] = string(v.(lua.String))
-	}
+	headers.Pairs(func(k, v lua.Value) bool {
+		reqHeaders[lua.MustString(k)] = lua.MustString(v)
+		return true
+	})
 
 	return request{
-		url:      string(url),
-		method:   string(method),
+		url:      lua.MustString(args[1]),
+		method:   method,
 		headers:  reqHeaders,
-		body:     string(body),
-		noBody:   bool(noBody),
-		noHeader: bool(noHeader),
+		body:     body,
+		noBody:   noBody,
+		noHeader: noHeader,
 	}, nil
-
 }
 
 func (s *HttpService) Execute(args []lua.Value) (ret []lua.Value, err error) {
@@ -171,8 +157,5 @@ func buildResponse(resp *http.Response, noHeader bool, noBody bool) []lua.Value
 }
 
 func buildError(msg string) []lua.Value {
-	return []lua.Value{
-		lua.Boolean(false),
-		lua.String(msg),
-	}
+	return lua.Args(false, msg)
 }
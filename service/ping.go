@@ -9,6 +9,10 @@ import (
 
 type PingService struct{}
 
+var pingArgs = lua.Schema{
+	lua.Str("method"),
+}
+
 func NewPingService() Service {
 	return &PingService{}
 }
@@ -17,23 +21,21 @@ func (s *PingService) Execute(args []lua.Value) (ret []lua.Value, err error) {
 	defer func() {
 		if err := recover(); err != nil {
 			slog.Warn("ping service panic", "err", err)
-			ret = []lua.Value{lua.String("error panic")}
+			ret = lua.Args("error panic")
 			err = fmt.Errorf("panic: %v", err)
 		}
 	}()
 
-	if len(args) < 1 {
-		return []lua.Value{lua.String("error args")}, nil
-	}
-
-	method, ok := args[0].(lua.String)
-	if !ok {
-		return []lua.Value{lua.String("error args")}, nil
+	args, err = pingArgs.Validate(args)
+	if err != nil {
+		slog.Warn("ping service invalid args", "err", err)
+		return lua.Args("error args"), nil
 	}
 
+	method := lua.MustString(args[0])
 	if method == "ping" {
-		return []lua.Value{lua.String("pong")}, nil
+		return lua.Args("pong"), nil
 	}
 
-	return []lua.Value{lua.String("error method")}, nil
+	return lua.Args("error method"), nil
 }
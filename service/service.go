@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"github.com/Zwlin98/moon/lua"
 )
 
@@ -8,4 +10,36 @@ type Service interface {
 	Execute([]lua.Value) ([]lua.Value, error)
 }
 
+// ContextService 是可选接口, 实现后调用时会传入请求的 context, 其中带有 skynet 的 trace tag
+type ContextService interface {
+	ExecuteContext(context.Context, []lua.Value) ([]lua.Value, error)
+}
+
+// Initializer 是可选接口, 注册服务时调用 Init, 返回错误时注册失败.
+// 服务也可以实现 io.Closer, 注销或被替换的服务在调用结束后 Close
+type Initializer interface {
+	Init(context.Context) error
+}
+
 type LuaFunction func([]lua.Value) ([]lua.Value, error)
+
+// Execute 调用服务, 服务实现了 ContextService 时使用 ExecuteContext
+func Execute(ctx context.Context, svc Service, args []lua.Value) ([]lua.Value, error) {
+	if cs, ok := svc.(ContextService); ok {
+		return cs.ExecuteContext(ctx, args)
+	}
+	return svc.Execute(args)
+}
+
+type traceTagKey struct{}
+
+// WithTraceTag 返回带有 trace tag 的 context, 使用该 context 发起的 cluster 调用会继续传递 trace tag
+func WithTraceTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, traceTagKey{}, tag)
+}
+
+// TraceTag 返回 context 中的 trace tag, 即 skynet.trace() 生成的标记
+func TraceTag(ctx context.Context) (string, bool) {
+	tag, ok := ctx.Value(traceTagKey{}).(string)
+	return tag, ok && tag != ""
+}
@@ -0,0 +1,459 @@
+package sproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Zwlin98/moon/lua"
+)
+
+// 与 skynet sproto 相同的编码格式:
+// 1. 消息以 2 字节的字段个数开始, 每个字段占 2 字节
+// 2. 字段值为偶数 v 时表示内联的整数 v/2-1, 为 0 时值在数据部分, 为奇数时表示跳过 (v+1)/2 个 tag
+// 3. 数据部分的每一项以 4 字节的长度开始
+// 所有数字均为小端序
+
+func (sp *Sproto) Encode(typeName string, v lua.Table) ([]byte, error) {
+	t := sp.types[typeName]
+	if t == nil {
+		return nil, fmt.Errorf("sproto type %s not found", typeName)
+	}
+	return t.Encode(v)
+}
+
+func (sp *Sproto) Decode(typeName string, data []byte) (lua.Table, error) {
+	t := sp.types[typeName]
+	if t == nil {
+		return lua.Table{}, fmt.Errorf("sproto type %s not found", typeName)
+	}
+	v, _, err := t.Decode(data)
+	return v, err
+}
+
+func (t *Type) Encode(v lua.Table) ([]byte, error) {
+	return encodeStruct(t, v, t.Name)
+}
+
+// Decode 返回解码后的 Table 以及消息占用的字节数
+func (t *Type) Decode(data []byte) (lua.Table, int, error) {
+	return decodeStruct(t, data, t.Name)
+}
+
+func appendChunk(data []byte, chunk []byte) []byte {
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(chunk)))
+	return append(data, chunk...)
+}
+
+func encodeStruct(t *Type, v lua.Table, path string) ([]byte, error) {
+	header := make([]uint16, 0, len(t.Fields))
+	var data []byte
+	lastTag := -1
+	for _, f := range t.Fields {
+		fv, ok := v.Get(f.Name)
+		if !ok {
+			continue
+		}
+		fieldPath := path + "." + f.Name
+		var value uint16
+		var err error
+		start := len(data)
+		if f.Array {
+			data, err = encodeArray(f, fv, data, fieldPath)
+		} else {
+			value, data, err = encodeValue(f, fv, data, fieldPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if skip := f.Tag - lastTag - 1; skip > 0 {
+			header = append(header, uint16((skip-1)*2+1))
+		}
+		if len(data) > start {
+			value = 0
+		}
+		header = append(header, value)
+		lastTag = f.Tag
+	}
+	buf := make([]byte, 0, 2+len(header)*2+len(data))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(header)))
+	for _, h := range header {
+		buf = binary.LittleEndian.AppendUint16(buf, h)
+	}
+	return append(buf, data...), nil
+}
+
+func mismatch(path string, expected string, v lua.Value) error {
+	return fmt.Errorf("%s: type mismatch, expected %s, got %s", path, expected, lua.Format(v))
+}
+
+func toInteger(f *Field, v lua.Value, path string) (int64, error) {
+	switch n := v.(type) {
+	case lua.Integer:
+		if f.Decimal > 0 {
+			return int64(n) * int64(f.Decimal), nil
+		}
+		return int64(n), nil
+	case lua.Real:
+		r := float64(n)
+		if f.Decimal > 0 {
+			return int64(math.Round(r * float64(f.Decimal))), nil
+		}
+		if r == math.Trunc(r) && r >= math.MinInt64 && r < math.MaxInt64 {
+			return int64(r), nil
+		}
+	}
+	return 0, mismatch(path, "integer", v)
+}
+
+// encodeValue 返回内联的字段值, 或者将值写入数据部分
+func encodeValue(f *Field, v lua.Value, data []byte, path string) (uint16, []byte, error) {
+	switch f.Type {
+	case TYPE_INTEGER:
+		n, err := toInteger(f, v, path)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n >= 0 && n < 0x7fff {
+			return uint16((n + 1) * 2), data, nil
+		}
+		if n>>31 == 0 || n>>31 == -1 {
+			data = binary.LittleEndian.AppendUint32(data, 4)
+			return 0, binary.LittleEndian.AppendUint32(data, uint32(n)), nil
+		}
+		data = binary.LittleEndian.AppendUint32(data, 8)
+		return 0, binary.LittleEndian.AppendUint64(data, uint64(n)), nil
+	case TYPE_BOOLEAN:
+		b, ok := v.(lua.Boolean)
+		if !ok {
+			return 0, nil, mismatch(path, "boolean", v)
+		}
+		if b {
+			return 4, data, nil
+		}
+		return 2, data, nil
+	case TYPE_DOUBLE:
+		r, ok := toDouble(v)
+		if !ok {
+			return 0, nil, mismatch(path, "double", v)
+		}
+		data = binary.LittleEndian.AppendUint32(data, 8)
+		return 0, binary.LittleEndian.AppendUint64(data, math.Float64bits(r)), nil
+	case TYPE_STRING:
+		switch s := v.(type) {
+		case lua.String:
+			return 0, appendChunk(data, []byte(s)), nil
+		case lua.Bytes:
+			return 0, appendChunk(data, s), nil
+		}
+		return 0, nil, mismatch(path, "string", v)
+	case TYPE_STRUCT:
+		t, ok := v.(lua.Table)
+		if !ok {
+			return 0, nil, mismatch(path, f.Struct.Name, v)
+		}
+		sub, err := encodeStruct(f.Struct, t, path)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, appendChunk(data, sub), nil
+	}
+	return 0, nil, fmt.Errorf("%s: unknown field type %d", path, f.Type)
+}
+
+func toDouble(v lua.Value) (float64, bool) {
+	switch n := v.(type) {
+	case lua.Integer:
+		return float64(n), true
+	case lua.Real:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// arrayItems 按 ipairs 返回数组元素; map 类型按键排序返回对应的结构体
+func arrayItems(f *Field, t lua.Table, path string) ([]lua.Value, error) {
+	items := make([]lua.Value, 0, t.Len())
+	if f.Key == nil {
+		t.IPairs(func(_ int, v lua.Value) bool {
+			items = append(items, v)
+			return true
+		})
+		return items, nil
+	}
+	type entry struct {
+		key   lua.Value
+		value lua.Value
+	}
+	entries := make([]entry, 0)
+	t.Pairs(func(k, v lua.Value) bool {
+		entries = append(entries, entry{k, v})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return lessKey(entries[i].key, entries[j].key)
+	})
+	for _, e := range entries {
+		if !f.ValueMap {
+			items = append(items, e.value)
+			continue
+		}
+		valueField := f.Struct.Fields[1]
+		items = append(items, lua.Table{
+			Hash: map[lua.Value]lua.Value{
+				lua.String(f.Key.Name):      e.key,
+				lua.String(valueField.Name): e.value,
+			},
+		})
+	}
+	return items, nil
+}
+
+func lessKey(a, b lua.Value) bool {
+	if a.LuaType() != b.LuaType() {
+		return a.LuaType() < b.LuaType()
+	}
+	switch av := a.(type) {
+	case lua.Integer:
+		return av < b.(lua.Integer)
+	case lua.Real:
+		return av < b.(lua.Real)
+	case lua.String:
+		return av < b.(lua.String)
+	case lua.Boolean:
+		return !bool(av) && bool(b.(lua.Boolean))
+	}
+	return false
+}
+
+func encodeArray(f *Field, v lua.Value, data []byte, path string) ([]byte, error) {
+	t, ok := v.(lua.Table)
+	if !ok {
+		return nil, mismatch(path, "array", v)
+	}
+	items, err := arrayItems(f, t, path)
+	if err != nil {
+		return nil, err
+	}
+	sizePos := len(data)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	start := len(data)
+	switch f.Type {
+	case TYPE_INTEGER:
+		ints := make([]int64, len(items))
+		intLen := 4
+		for i, item := range items {
+			n, err := toInteger(f, item, fmt.Sprintf("%s[%d]", path, i+1))
+			if err != nil {
+				return nil, err
+			}
+			if n>>31 != 0 && n>>31 != -1 {
+				intLen = 8
+			}
+			ints[i] = n
+		}
+		if len(ints) > 0 {
+			data = append(data, uint8(intLen))
+		}
+		for _, n := range ints {
+			if intLen == 4 {
+				data = binary.LittleEndian.AppendUint32(data, uint32(n))
+			} else {
+				data = binary.LittleEndian.AppendUint64(data, uint64(n))
+			}
+		}
+	case TYPE_DOUBLE:
+		if len(items) > 0 {
+			data = append(data, 8)
+		}
+		for i, item := range items {
+			r, ok := toDouble(item)
+			if !ok {
+				return nil, mismatch(fmt.Sprintf("%s[%d]", path, i+1), "double", item)
+			}
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(r))
+		}
+	case TYPE_BOOLEAN:
+		for i, item := range items {
+			b, ok := item.(lua.Boolean)
+			if !ok {
+				return nil, mismatch(fmt.Sprintf("%s[%d]", path, i+1), "boolean", item)
+			}
+			if b {
+				data = append(data, 1)
+			} else {
+				data = append(data, 0)
+			}
+		}
+	default:
+		for i, item := range items {
+			_, chunk, err := encodeValue(f, item, nil, fmt.Sprintf("%s[%d]", path, i+1))
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, chunk...)
+		}
+	}
+	binary.LittleEndian.PutUint32(data[sizePos:], uint32(len(data)-start))
+	return data, nil
+}
+
+func decodeStruct(t *Type, data []byte, path string) (lua.Table, int, error) {
+	table := lua.Table{Hash: make(map[lua.Value]lua.Value)}
+	if len(data) < 2 {
+		return table, 0, fmt.Errorf("%s: invalid message, header is truncated", path)
+	}
+	fn := int(binary.LittleEndian.Uint16(data))
+	offset := 2 + fn*2
+	if len(data) < offset {
+		return table, 0, fmt.Errorf("%s: invalid message, fields are truncated", path)
+	}
+	tag := -1
+	for i := 0; i < fn; i++ {
+		tag++
+		value := int(binary.LittleEndian.Uint16(data[2+i*2:]))
+		if value&1 != 0 {
+			tag += value / 2
+			continue
+		}
+		value = value/2 - 1
+		var chunk []byte
+		if value < 0 {
+			if len(data) < offset+4 {
+				return table, 0, fmt.Errorf("%s: invalid message, data is truncated", path)
+			}
+			sz := int(binary.LittleEndian.Uint32(data[offset:]))
+			if sz < 0 || len(data)-offset-4 < sz {
+				return table, 0, fmt.Errorf("%s: invalid message, data is truncated", path)
+			}
+			chunk = data[offset+4 : offset+4+sz]
+			offset += 4 + sz
+		}
+		f := t.field(tag)
+		if f == nil {
+			continue
+		}
+		fieldPath := path + "." + f.Name
+		var v lua.Value
+		var err error
+		switch {
+		case value >= 0:
+			if f.Array || (f.Type != TYPE_INTEGER && f.Type != TYPE_BOOLEAN) {
+				return table, 0, fmt.Errorf("%s: invalid inline value", fieldPath)
+			}
+			v = inlineValue(f, int64(value))
+		case f.Array:
+			v, err = decodeArray(f, chunk, fieldPath)
+		default:
+			v, err = decodeValue(f, chunk, fieldPath)
+		}
+		if err != nil {
+			return table, 0, err
+		}
+		table.Hash[lua.String(f.Name)] = v
+	}
+	return table, offset, nil
+}
+
+func inlineValue(f *Field, n int64) lua.Value {
+	if f.Type == TYPE_BOOLEAN {
+		return lua.Boolean(n != 0)
+	}
+	return integerValue(f, n)
+}
+
+func integerValue(f *Field, n int64) lua.Value {
+	if f.Decimal > 0 {
+		return lua.Real(float64(n) / float64(f.Decimal))
+	}
+	return lua.Integer(n)
+}
+
+func decodeValue(f *Field, chunk []byte, path string) (lua.Value, error) {
+	switch f.Type {
+	case TYPE_INTEGER:
+		switch len(chunk) {
+		case 4:
+			return integerValue(f, int64(int32(binary.LittleEndian.Uint32(chunk)))), nil
+		case 8:
+			return integerValue(f, int64(binary.LittleEndian.Uint64(chunk))), nil
+		}
+		return nil, fmt.Errorf("%s: invalid integer size %d", path, len(chunk))
+	case TYPE_DOUBLE:
+		if len(chunk) != 8 {
+			return nil, fmt.Errorf("%s: invalid double size %d", path, len(chunk))
+		}
+		return lua.Real(math.Float64frombits(binary.LittleEndian.Uint64(chunk))), nil
+	case TYPE_STRING:
+		return lua.String(chunk), nil
+	case TYPE_STRUCT:
+		t, _, err := decodeStruct(f.Struct, chunk, path)
+		return t, err
+	}
+	return nil, fmt.Errorf("%s: invalid data for boolean", path)
+}
+
+func decodeArray(f *Field, chunk []byte, path string) (lua.Value, error) {
+	items := make([]lua.Value, 0)
+	switch f.Type {
+	case TYPE_INTEGER, TYPE_DOUBLE:
+		if len(chunk) == 0 {
+			break
+		}
+		intLen := int(chunk[0])
+		if (intLen != 4 && intLen != 8) || (f.Type == TYPE_DOUBLE && intLen != 8) || (len(chunk)-1)%intLen != 0 {
+			return nil, fmt.Errorf("%s: invalid array", path)
+		}
+		for i := 1; i < len(chunk); i += intLen {
+			if f.Type == TYPE_DOUBLE {
+				items = append(items, lua.Real(math.Float64frombits(binary.LittleEndian.Uint64(chunk[i:]))))
+			} else if intLen == 4 {
+				items = append(items, integerValue(f, int64(int32(binary.LittleEndian.Uint32(chunk[i:])))))
+			} else {
+				items = append(items, integerValue(f, int64(binary.LittleEndian.Uint64(chunk[i:]))))
+			}
+		}
+	case TYPE_BOOLEAN:
+		for _, b := range chunk {
+			items = append(items, lua.Boolean(b != 0))
+		}
+	default:
+		for len(chunk) > 0 {
+			if len(chunk) < 4 {
+				return nil, fmt.Errorf("%s: invalid array", path)
+			}
+			sz := int(binary.LittleEndian.Uint32(chunk))
+			if sz < 0 || len(chunk)-4 < sz {
+				return nil, fmt.Errorf("%s: invalid array", path)
+			}
+			v, err := decodeValue(f, chunk[4:4+sz], fmt.Sprintf("%s[%d]", path, len(items)+1))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			chunk = chunk[4+sz:]
+		}
+	}
+	if f.Key == nil {
+		return lua.Table{Array: items}, nil
+	}
+	hash := make(map[lua.Value]lua.Value, len(items))
+	for _, item := range items {
+		st := item.(lua.Table)
+		key, ok := st.Get(f.Key.Name)
+		if !ok {
+			return nil, fmt.Errorf("%s: map key %s not found", path, f.Key.Name)
+		}
+		if f.ValueMap {
+			value, ok := st.Get(f.Struct.Fields[1].Name)
+			if !ok {
+				continue
+			}
+			hash[key] = value
+		} else {
+			hash[key] = item
+		}
+	}
+	return lua.Table{Hash: hash}, nil
+}
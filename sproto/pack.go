@@ -0,0 +1,98 @@
+package sproto
+
+import (
+	"errors"
+)
+
+// 0 压缩: 每 8 字节为一组, 以一个位图字节开始, 后跟组内的非零字节
+// 位图为 0xff 时后跟一个字节 n, 表示接下来 (n+1) 组共 (n+1)*8 字节不做压缩
+
+func packGroup(src []byte, dst []byte) ([]byte, int) {
+	header := 0
+	pos := len(dst)
+	dst = append(dst, 0)
+	notZero := 0
+	for i := 0; i < 8; i++ {
+		if src[i] != 0 {
+			notZero++
+			header |= 1 << i
+			dst = append(dst, src[i])
+		}
+	}
+	dst[pos] = byte(header)
+	return dst, notZero
+}
+
+// Pack 按 sproto 的 0 压缩格式压缩 data, 长度不足 8 的倍数时补 0
+func Pack(data []byte) []byte {
+	if n := len(data) % 8; n != 0 {
+		padded := make([]byte, len(data)+8-n)
+		copy(padded, data)
+		data = padded
+	}
+	dst := make([]byte, 0, len(data)+len(data)/8+2)
+	ffStart := -1 // 当前 0xff 段在 dst 中的位置
+	ffGroups := 0
+	for i := 0; i < len(data); i += 8 {
+		group := data[i : i+8]
+		start := len(dst)
+		var notZero int
+		dst, notZero = packGroup(group, dst)
+		switch {
+		case notZero == 8 && ffGroups == 0:
+			// 开始一个新的 0xff 段
+			dst = append(dst[:start], 0xff, 0)
+			dst = append(dst, group...)
+			ffStart = start
+			ffGroups = 1
+		case ffGroups > 0 && notZero >= 6:
+			// 6 个以上非零字节的组并入当前段更短
+			dst = append(dst[:start], group...)
+			ffGroups++
+		default:
+			ffGroups = 0
+		}
+		if ffGroups > 0 {
+			dst[ffStart+1] = byte(ffGroups - 1)
+			if ffGroups == 256 {
+				ffGroups = 0
+			}
+		}
+	}
+	return dst
+}
+
+var errInvalidPacked = errors.New("sproto: invalid packed data")
+
+// Unpack 解压 Pack 的结果, 结果的长度总是 8 的倍数
+func Unpack(data []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(data)*2)
+	for len(data) > 0 {
+		header := data[0]
+		data = data[1:]
+		if header == 0xff {
+			if len(data) < 1 {
+				return nil, errInvalidPacked
+			}
+			n := (int(data[0]) + 1) * 8
+			if len(data) < n+1 {
+				return nil, errInvalidPacked
+			}
+			dst = append(dst, data[1:n+1]...)
+			data = data[n+1:]
+			continue
+		}
+		for i := 0; i < 8; i++ {
+			if header&(1<<i) == 0 {
+				dst = append(dst, 0)
+				continue
+			}
+			if len(data) < 1 {
+				return nil, errInvalidPacked
+			}
+			dst = append(dst, data[0])
+			data = data[1:]
+		}
+	}
+	return dst, nil
+}
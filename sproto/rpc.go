@@ -0,0 +1,166 @@
+package sproto
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Zwlin98/moon/lua"
+)
+
+// 与 skynet sproto.lua 的 host/attach 相同的 RPC 封装:
+// 每个包由 package 类型的包头 { type, session, ud } 和协议内容拼接后 0 压缩得到,
+// 包头中有 type 的是请求, 没有 type 的是对 session 的回应
+
+const DEFAULT_PACKAGE = "package"
+
+const (
+	MESSAGE_REQUEST uint8 = iota
+	MESSAGE_RESPONSE
+)
+
+type Message struct {
+	Kind    uint8
+	Name    string // 请求的协议名
+	Session int64  // 0 表示不需要回应
+	UD      int64
+	Args    lua.Table // 请求参数或者回应内容
+
+	host     *Host
+	protocol *Protocol
+}
+
+type Host struct {
+	sp       *Sproto
+	pkg      *Type
+	mu       sync.Mutex
+	sessions map[int64]*Protocol
+}
+
+// NewHost 使用 sp 处理收到的请求, packageName 为空时使用 DEFAULT_PACKAGE
+func (sp *Sproto) NewHost(packageName string) (*Host, error) {
+	if packageName == "" {
+		packageName = DEFAULT_PACKAGE
+	}
+	pkg := sp.types[packageName]
+	if pkg == nil {
+		return nil, fmt.Errorf("sproto package type %s not found", packageName)
+	}
+	return &Host{
+		sp:       sp,
+		pkg:      pkg,
+		sessions: make(map[int64]*Protocol),
+	}, nil
+}
+
+func (h *Host) encodeHeader(tag int, session int64, ud int64) ([]byte, error) {
+	header := lua.Table{Hash: make(map[lua.Value]lua.Value)}
+	if tag >= 0 {
+		header.Hash[lua.String("type")] = lua.Integer(tag)
+	}
+	if session != 0 {
+		header.Hash[lua.String("session")] = lua.Integer(session)
+	}
+	if ud != 0 {
+		header.Hash[lua.String("ud")] = lua.Integer(ud)
+	}
+	return h.pkg.Encode(header)
+}
+
+func encodePackage(header []byte, t *Type, args lua.Table) ([]byte, error) {
+	if t == nil {
+		return Pack(header), nil
+	}
+	content, err := t.Encode(args)
+	if err != nil {
+		return nil, err
+	}
+	return Pack(append(header, content...)), nil
+}
+
+// Request 使用对端的协议 remote 编码一个请求, session 不为 0 时会记录下来用于解码回应
+func (h *Host) Request(remote *Sproto, name string, args lua.Table, session int64, ud int64) ([]byte, error) {
+	proto := remote.protocols[name]
+	if proto == nil {
+		return nil, fmt.Errorf("sproto protocol %s not found", name)
+	}
+	header, err := h.encodeHeader(proto.Tag, session, ud)
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodePackage(header, proto.Request, args)
+	if err != nil {
+		return nil, err
+	}
+	if session != 0 {
+		h.mu.Lock()
+		h.sessions[session] = proto
+		h.mu.Unlock()
+	}
+	return data, nil
+}
+
+// Dispatch 解码收到的包, 返回请求或者回应
+func (h *Host) Dispatch(data []byte) (*Message, error) {
+	bin, err := Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	header, size, err := h.pkg.Decode(bin)
+	if err != nil {
+		return nil, err
+	}
+	content := bin[size:]
+	session, _ := header.GetInt("session")
+	ud, _ := header.GetInt("ud")
+	msg := &Message{
+		Session: session,
+		UD:      ud,
+		host:    h,
+	}
+	if tag, ok := header.GetInt("type"); ok {
+		proto := h.sp.tags[int(tag)]
+		if proto == nil {
+			return nil, fmt.Errorf("sproto protocol tag %d not found", tag)
+		}
+		msg.Kind = MESSAGE_REQUEST
+		msg.Name = proto.Name
+		msg.protocol = proto
+		if proto.Request != nil {
+			if msg.Args, _, err = proto.Request.Decode(content); err != nil {
+				return nil, err
+			}
+		}
+		return msg, nil
+	}
+
+	if session == 0 {
+		return nil, fmt.Errorf("sproto response session not found")
+	}
+	h.mu.Lock()
+	proto, ok := h.sessions[session]
+	delete(h.sessions, session)
+	h.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("sproto unknown session %d", session)
+	}
+	msg.Kind = MESSAGE_RESPONSE
+	msg.Name = proto.Name
+	if proto.Response != nil {
+		if msg.Args, _, err = proto.Response.Decode(content); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Response 编码对请求 m 的回应, 不需要回应的请求返回错误
+func (m *Message) Response(result lua.Table, ud int64) ([]byte, error) {
+	if m.Kind != MESSAGE_REQUEST || m.Session == 0 {
+		return nil, fmt.Errorf("sproto %s: no response needed", m.Name)
+	}
+	header, err := m.host.encodeHeader(-1, m.Session, ud)
+	if err != nil {
+		return nil, err
+	}
+	return encodePackage(header, m.protocol.Response, result)
+}
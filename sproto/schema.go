@@ -0,0 +1,479 @@
+package sproto
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 解析 .sproto 格式的协议描述, 语法与 skynet 的 sprotoparser.lua 一致:
+//
+//	.Person {
+//		name 0 : string
+//		phone 1 : *PhoneNumber
+//		.PhoneNumber {
+//			number 0 : string
+//		}
+//	}
+//
+//	foobar 1 {
+//		request Person
+//		response {
+//			ok 0 : boolean
+//		}
+//	}
+
+type FieldType uint8
+
+const (
+	TYPE_INTEGER FieldType = iota
+	TYPE_BOOLEAN
+	TYPE_STRING
+	TYPE_DOUBLE
+	TYPE_STRUCT
+)
+
+type Field struct {
+	Name    string
+	Tag     int
+	Type    FieldType
+	Struct  *Type // for TYPE_STRUCT
+	Array   bool
+	Binary  bool
+	Decimal int // integer(n) 时为 10^n
+
+	// map 类型, *Type(key) 以 key 字段为键; *Type() 以第一个字段为键, 第二个字段为值
+	Key      *Field
+	ValueMap bool
+
+	typeName string
+	keyName  string
+	hasKey   bool
+}
+
+type Type struct {
+	Name   string
+	Fields []*Field // 按 tag 排序
+}
+
+func (t *Type) field(tag int) *Field {
+	i := sort.Search(len(t.Fields), func(i int) bool {
+		return t.Fields[i].Tag >= tag
+	})
+	if i < len(t.Fields) && t.Fields[i].Tag == tag {
+		return t.Fields[i]
+	}
+	return nil
+}
+
+type Protocol struct {
+	Name     string
+	Tag      int
+	Request  *Type
+	Response *Type
+	Confirm  bool // response nil, 需要回应但没有内容
+}
+
+type Sproto struct {
+	types     map[string]*Type
+	protocols map[string]*Protocol
+	tags      map[int]*Protocol
+}
+
+func (sp *Sproto) Type(name string) *Type {
+	return sp.types[name]
+}
+
+func (sp *Sproto) Protocol(name string) *Protocol {
+	return sp.protocols[name]
+}
+
+func (sp *Sproto) ProtocolByTag(tag int) *Protocol {
+	return sp.tags[tag]
+}
+
+func Parse(schema string) (*Sproto, error) {
+	p := &schemaParser{
+		lexer: schemaLexer{src: schema, line: 1},
+		sp: &Sproto{
+			types:     make(map[string]*Type),
+			protocols: make(map[string]*Protocol),
+			tags:      make(map[int]*Protocol),
+		},
+		scopes: make(map[*Type]string),
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return p.sp, nil
+}
+
+type schemaLexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9') || c == '.'
+}
+
+// next 返回下一个词法单元, 名字可以包含 '.', 类型定义以 '.' 开头
+func (l *schemaLexer) next() string {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case isNameChar(c):
+			start := l.pos
+			l.pos++
+			for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+				l.pos++
+			}
+			return l.src[start:l.pos]
+		default:
+			l.pos++
+			return string(c)
+		}
+	}
+	return ""
+}
+
+func (l *schemaLexer) peek() string {
+	pos, line := l.pos, l.line
+	tok := l.next()
+	l.pos, l.line = pos, line
+	return tok
+}
+
+type schemaParser struct {
+	lexer  schemaLexer
+	sp     *Sproto
+	fields []*Field
+	scopes map[*Type]string // 类型所在的作用域, 用于解析字段类型
+}
+
+func (p *schemaParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("sproto schema line %d: %s", p.lexer.line, fmt.Sprintf(format, args...))
+}
+
+func (p *schemaParser) expect(tok string) error {
+	if got := p.lexer.next(); got != tok {
+		return p.errorf("%q expected, got %q", tok, got)
+	}
+	return nil
+}
+
+func (p *schemaParser) name() (string, error) {
+	tok := p.lexer.next()
+	if tok == "" || !isNameStart(tok[0]) || strings.Contains(tok, ".") {
+		return "", p.errorf("name expected, got %q", tok)
+	}
+	return tok, nil
+}
+
+func (p *schemaParser) tag() (int, error) {
+	tok := p.lexer.next()
+	tag, err := strconv.Atoi(tok)
+	if err != nil || tag < 0 || tag >= 0x7fff {
+		return 0, p.errorf("invalid tag %q", tok)
+	}
+	return tag, nil
+}
+
+func (p *schemaParser) parse() error {
+	for {
+		tok := p.lexer.peek()
+		switch {
+		case tok == "":
+			return nil
+		case strings.HasPrefix(tok, "."):
+			p.lexer.next()
+			if err := p.parseType(tok[1:], ""); err != nil {
+				return err
+			}
+		default:
+			if err := p.parseProtocol(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *schemaParser) addType(fullname string, scope string) (*Type, error) {
+	if _, ok := p.sp.types[fullname]; ok {
+		return nil, p.errorf("redefined type %s", fullname)
+	}
+	t := &Type{Name: fullname}
+	p.sp.types[fullname] = t
+	p.scopes[t] = scope
+	return t, nil
+}
+
+// parseType 解析 .Name { ... }, 左括号之前的名字已经读取
+func (p *schemaParser) parseType(name string, prefix string) error {
+	if name == "" || strings.Contains(name, ".") {
+		return p.errorf("invalid type name %q", name)
+	}
+	fullname := name
+	if prefix != "" {
+		fullname = prefix + "." + name
+	}
+	t, err := p.addType(fullname, fullname)
+	if err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	return p.parseFields(t)
+}
+
+func (p *schemaParser) parseFields(t *Type) error {
+	names := make(map[string]bool)
+	for {
+		tok := p.lexer.peek()
+		switch {
+		case tok == "}":
+			p.lexer.next()
+			sort.Slice(t.Fields, func(i, j int) bool {
+				return t.Fields[i].Tag < t.Fields[j].Tag
+			})
+			for i := 1; i < len(t.Fields); i++ {
+				if t.Fields[i].Tag == t.Fields[i-1].Tag {
+					return p.errorf("redefined tag %d in type %s", t.Fields[i].Tag, t.Name)
+				}
+			}
+			return nil
+		case tok == "":
+			return p.errorf("'}' expected for type %s", t.Name)
+		case strings.HasPrefix(tok, "."):
+			p.lexer.next()
+			if err := p.parseType(tok[1:], t.Name); err != nil {
+				return err
+			}
+		default:
+			f, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			if names[f.Name] {
+				return p.errorf("redefined field %s in type %s", f.Name, t.Name)
+			}
+			names[f.Name] = true
+			t.Fields = append(t.Fields, f)
+		}
+	}
+}
+
+func (p *schemaParser) parseField() (*Field, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	tag, err := p.tag()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	f := &Field{Name: name, Tag: tag}
+	typ := p.lexer.next()
+	if typ == "*" {
+		f.Array = true
+		typ = p.lexer.next()
+	}
+	if typ == "" || !isNameStart(typ[0]) {
+		return nil, p.errorf("type expected for field %s, got %q", name, typ)
+	}
+	f.typeName = typ
+	if p.lexer.peek() == "(" {
+		p.lexer.next()
+		arg := p.lexer.next()
+		if arg != ")" {
+			f.keyName = arg
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		f.hasKey = true
+	}
+	p.fields = append(p.fields, f)
+	return f, nil
+}
+
+func (p *schemaParser) parseProtocol() error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	tag, err := p.tag()
+	if err != nil {
+		return err
+	}
+	if _, ok := p.sp.protocols[name]; ok {
+		return p.errorf("redefined protocol %s", name)
+	}
+	if _, ok := p.sp.tags[tag]; ok {
+		return p.errorf("redefined protocol tag %d", tag)
+	}
+	proto := &Protocol{Name: name, Tag: tag}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		tok := p.lexer.next()
+		if tok == "}" {
+			break
+		}
+		if tok != "request" && tok != "response" {
+			return p.errorf("request or response expected in protocol %s, got %q", name, tok)
+		}
+		var t *Type
+		switch next := p.lexer.next(); next {
+		case "{":
+			t, err = p.addType(name+"."+tok, name+"."+tok)
+			if err != nil {
+				return err
+			}
+			if err := p.parseFields(t); err != nil {
+				return err
+			}
+		case "nil":
+			if tok != "response" {
+				return p.errorf("request nil is not allowed in protocol %s", name)
+			}
+			proto.Confirm = true
+		default:
+			if next == "" || !isNameStart(next[0]) {
+				return p.errorf("type expected in protocol %s, got %q", name, next)
+			}
+			t = &Type{Name: next} // 在 resolve 中替换
+		}
+		if tok == "request" {
+			proto.Request = t
+		} else {
+			proto.Response = t
+		}
+	}
+	p.sp.protocols[name] = proto
+	p.sp.tags[tag] = proto
+	return nil
+}
+
+var builtinTypes = map[string]FieldType{
+	"integer": TYPE_INTEGER,
+	"boolean": TYPE_BOOLEAN,
+	"string":  TYPE_STRING,
+	"binary":  TYPE_STRING,
+	"double":  TYPE_DOUBLE,
+}
+
+// lookup 与 sprotoparser.lua 相同, 从内层作用域向外查找类型
+func (p *schemaParser) lookup(scope string, name string) *Type {
+	for scope != "" {
+		if t, ok := p.sp.types[scope+"."+name]; ok {
+			return t
+		}
+		i := strings.LastIndexByte(scope, '.')
+		if i < 0 {
+			break
+		}
+		scope = scope[:i]
+	}
+	return p.sp.types[name]
+}
+
+func (p *schemaParser) resolve() error {
+	owners := make(map[*Field]*Type)
+	for _, t := range p.sp.types {
+		for _, f := range t.Fields {
+			owners[f] = t
+		}
+	}
+	for _, f := range p.fields {
+		owner := owners[f]
+		if typ, ok := builtinTypes[f.typeName]; ok {
+			f.Type = typ
+			f.Binary = f.typeName == "binary"
+			if f.hasKey {
+				if typ != TYPE_INTEGER || f.keyName == "" {
+					return fmt.Errorf("sproto schema: invalid type %s(%s) for %s.%s", f.typeName, f.keyName, owner.Name, f.Name)
+				}
+				digits, err := strconv.Atoi(f.keyName)
+				if err != nil || digits <= 0 || digits > 18 {
+					return fmt.Errorf("sproto schema: invalid decimal %s for %s.%s", f.keyName, owner.Name, f.Name)
+				}
+				f.Decimal = 1
+				for i := 0; i < digits; i++ {
+					f.Decimal *= 10
+				}
+			}
+			continue
+		}
+		t := p.lookup(p.scopes[owner], f.typeName)
+		if t == nil {
+			return fmt.Errorf("sproto schema: undefined type %s for %s.%s", f.typeName, owner.Name, f.Name)
+		}
+		f.Type = TYPE_STRUCT
+		f.Struct = t
+		if !f.hasKey {
+			continue
+		}
+		if !f.Array {
+			return fmt.Errorf("sproto schema: map %s.%s must be an array", owner.Name, f.Name)
+		}
+		if f.keyName == "" {
+			if len(t.Fields) != 2 {
+				return fmt.Errorf("sproto schema: map %s.%s needs a type with two fields", owner.Name, f.Name)
+			}
+			f.Key = t.Fields[0]
+			f.ValueMap = true
+		} else {
+			for _, kf := range t.Fields {
+				if kf.Name == f.keyName {
+					f.Key = kf
+				}
+			}
+			if f.Key == nil {
+				return fmt.Errorf("sproto schema: invalid map index %s for %s.%s", f.keyName, owner.Name, f.Name)
+			}
+		}
+	}
+	// 检查 map 的键, 此时所有字段的类型已经确定
+	for _, f := range p.fields {
+		if f.Key != nil && (f.Key.Array || f.Key.Type == TYPE_STRUCT || f.Key.Type == TYPE_DOUBLE) {
+			return fmt.Errorf("sproto schema: invalid map index type %s for %s", f.Key.typeName, f.Name)
+		}
+	}
+	for _, proto := range p.sp.protocols {
+		for _, t := range []**Type{&proto.Request, &proto.Response} {
+			if *t == nil || p.sp.types[(*t).Name] == *t {
+				continue
+			}
+			resolved := p.sp.types[(*t).Name]
+			if resolved == nil {
+				return fmt.Errorf("sproto schema: undefined type %s in protocol %s", (*t).Name, proto.Name)
+			}
+			*t = resolved
+		}
+	}
+	return nil
+}
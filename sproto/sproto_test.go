@@ -0,0 +1,222 @@
+package sproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/Zwlin98/moon/lua"
+)
+
+const testSchema = `
+.package {
+	type 0 : integer
+	session 1 : integer
+	ud 2 : integer
+}
+
+.Person {
+	name 0 : string
+	age 1 : integer
+	marital 2 : boolean
+	children 3 : *Person
+}
+
+.Data {
+	numbers 0 : *integer
+	bools 1 : *boolean
+	number 2 : integer
+	bignumber 3 : integer
+	double 4 : double
+	doubles 5 : *double
+	fpn 6 : integer(2)
+}
+
+.AddressBook {
+	person 0 : *Person(name)
+	scores 1 : *Score()
+	.Score {
+		name 0 : string
+		score 1 : integer
+	}
+}
+
+foobar 1 {
+	request Person
+	response {
+		ok 0 : boolean
+	}
+}
+
+ping 2 {}
+`
+
+func literal(t *testing.T, src string) lua.Table {
+	values, err := lua.ParseLiteral(src)
+	if err != nil {
+		t.Fatalf("parse %s failed: %v", src, err)
+	}
+	return values[0].(lua.Table)
+}
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// 编码结果来自 sproto 的 README
+func TestEncodeDecode(t *testing.T) {
+	sp, err := Parse(testSchema)
+	if err != nil {
+		t.Fatalf("parse schema failed: %v", err)
+	}
+	cases := []struct {
+		typeName string
+		value    string
+		encoded  string
+	}{
+		{"Person", `{name = "Alice", age = 13, marital = false}`,
+			"03 00 00 00 1C 00 02 00 05 00 00 00 41 6C 69 63 65"},
+		{"Person", `{name = "Bob", age = 40, children = {{name = "Alice", age = 13}, {name = "Carol", age = 5}}}`,
+			"04 00 00 00 52 00 01 00 00 00 03 00 00 00 42 6F 62 26 00 00 00" +
+				"0F 00 00 00 02 00 00 00 1C 00 05 00 00 00 41 6C 69 63 65" +
+				"0F 00 00 00 02 00 00 00 0C 00 05 00 00 00 43 61 72 6F 6C"},
+		{"Data", `{numbers = {1, 2, 3, 4, 5}}`,
+			"01 00 00 00 15 00 00 00 04 01 00 00 00 02 00 00 00 03 00 00 00 04 00 00 00 05 00 00 00"},
+		{"Data", `{numbers = {4294967297, 4294967298, 4294967299}}`,
+			"01 00 00 00 19 00 00 00 08 01 00 00 00 01 00 00 00 02 00 00 00 01 00 00 00 03 00 00 00 01 00 00 00"},
+		{"Data", `{bools = {false, true, false}}`,
+			"02 00 01 00 00 00 03 00 00 00 00 01 00"},
+		{"Data", `{number = 100000, bignumber = -10000000000}`,
+			"03 00 03 00 00 00 00 00 04 00 00 00 A0 86 01 00 08 00 00 00 00 1C F4 AB FD FF FF FF"},
+		{"Data", `{double = 0.01171875, doubles = {0.01171875, 23.0, 4.0}}`,
+			"03 00 07 00 00 00 00 00 08 00 00 00 00 00 00 00 00 00 88 3f" +
+				"19 00 00 00 08 00 00 00 00 00 00 88 3f 00 00 00 00 00 00 37 40 00 00 00 00 00 00 10 40"},
+		{"Data", `{fpn = 1.82}`, "02 00 0b 00 6e 01"},
+	}
+	for _, c := range cases {
+		value := literal(t, c.value)
+		encoded, err := sp.Encode(c.typeName, value)
+		if err != nil {
+			t.Fatalf("encode %s failed: %v", c.value, err)
+		}
+		if expected := unhex(c.encoded); !bytes.Equal(encoded, expected) {
+			t.Errorf("encode %s not match:\n% x\n% x", c.value, encoded, expected)
+		}
+		decoded, err := sp.Decode(c.typeName, encoded)
+		if err != nil {
+			t.Fatalf("decode %s failed: %v", c.value, err)
+		}
+		if !lua.Equal(decoded, value) {
+			t.Errorf("decode not match: %v != %v", decoded, value)
+		}
+	}
+}
+
+func TestEncodeMap(t *testing.T) {
+	sp, err := Parse(testSchema)
+	if err != nil {
+		t.Fatalf("parse schema failed: %v", err)
+	}
+	value := literal(t, `{
+		person = {Alice = {name = "Alice", age = 13}, Bob = {name = "Bob", age = 40}},
+		scores = {Alice = 90, Bob = 60},
+	}`)
+	encoded, err := sp.Encode("AddressBook", value)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	decoded, err := sp.Decode("AddressBook", encoded)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !lua.Equal(decoded, value) {
+		t.Errorf("decode not match: %v != %v", decoded, value)
+	}
+
+	_, err = sp.Encode("Person", literal(t, `{name = 1}`))
+	if err == nil || !strings.Contains(err.Error(), "Person.name") {
+		t.Errorf("expected type mismatch, got %v", err)
+	}
+	if _, err := sp.Decode("Person", encoded[:len(encoded)-1]); err == nil {
+		t.Errorf("expected truncated error")
+	}
+}
+
+func TestPack(t *testing.T) {
+	cases := []struct {
+		unpacked string
+		packed   string
+	}{
+		{"08 00 00 00 03 00 02 00 19 00 00 00 aa 01 00 00", "51 08 03 02 31 19 aa 01"},
+		{strings.Repeat("8a", 30), "ff 03" + strings.Repeat("8a", 30) + "00 00"},
+		{strings.Repeat("01", 8) + strings.Repeat("00", 8), "ff 00 01 01 01 01 01 01 01 01 00"},
+	}
+	for _, c := range cases {
+		packed := Pack(unhex(c.unpacked))
+		if expected := unhex(c.packed); !bytes.Equal(packed, expected) {
+			t.Errorf("pack not match:\n% x\n% x", packed, expected)
+		}
+		unpacked, err := Unpack(packed)
+		if err != nil {
+			t.Fatalf("unpack failed: %v", err)
+		}
+		if expected := unhex(c.unpacked); !bytes.Equal(unpacked[:len(expected)], expected) {
+			t.Errorf("unpack not match:\n% x\n% x", unpacked, expected)
+		}
+	}
+
+	long := bytes.Repeat([]byte{1}, 8*300)
+	unpacked, err := Unpack(Pack(long))
+	if err != nil || !bytes.Equal(unpacked, long) {
+		t.Errorf("long run not match: %v", err)
+	}
+}
+
+func TestRPC(t *testing.T) {
+	sp, err := Parse(testSchema)
+	if err != nil {
+		t.Fatalf("parse schema failed: %v", err)
+	}
+	client, _ := sp.NewHost("")
+	server, _ := sp.NewHost("")
+
+	req, err := client.Request(sp, "foobar", literal(t, `{name = "Alice", age = 13}`), 1, 0)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	msg, err := server.Dispatch(req)
+	if err != nil {
+		t.Fatalf("dispatch failed: %v", err)
+	}
+	if msg.Kind != MESSAGE_REQUEST || msg.Name != "foobar" || msg.Session != 1 || msg.Args.StringOr("name", "") != "Alice" {
+		t.Errorf("request not match: %+v", msg)
+	}
+	resp, err := msg.Response(literal(t, `{ok = true}`), 0)
+	if err != nil {
+		t.Fatalf("response failed: %v", err)
+	}
+	msg, err = client.Dispatch(resp)
+	if err != nil {
+		t.Fatalf("dispatch failed: %v", err)
+	}
+	if msg.Kind != MESSAGE_RESPONSE || msg.Name != "foobar" || !msg.Args.BoolOr("ok", false) {
+		t.Errorf("response not match: %+v", msg)
+	}
+	if _, err := client.Dispatch(resp); err == nil {
+		t.Errorf("expected unknown session error")
+	}
+
+	push, _ := client.Request(sp, "ping", lua.Table{}, 0, 0)
+	msg, err = server.Dispatch(push)
+	if err != nil || msg.Name != "ping" {
+		t.Fatalf("dispatch push failed: %v", err)
+	}
+	if _, err := msg.Response(lua.Table{}, 0); err == nil {
+		t.Errorf("expected no response error")
+	}
+}